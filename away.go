@@ -0,0 +1,78 @@
+package main
+
+import (
+	"time"
+
+	"github.com/acarl005/stripansi"
+)
+
+// setAway marks a user as away with a message
+func (u *user) setAway(msg string, auto bool) {
+	u.away = msg
+	u.awaySince = time.Now()
+	u.autoAway = auto
+}
+
+func (u *user) isAway() bool {
+	return u.away != ""
+}
+
+// markActive records that a user typed something, bringing them back if they were idle
+func (u *user) markActive() {
+	u.lastActive = time.Now()
+	if u.autoAway {
+		u.away = ""
+		u.autoAway = false
+	}
+}
+
+// awayNote describes why a user is away, like "bob is away (lunch) for 20m"
+func (u *user) awayNote() string {
+	return stripansi.Strip(u.name) + " is away (" + u.away + ") for " + printPrettyDuration(time.Since(u.awaySince))
+}
+
+// replyIfAway tells sender when the user they messaged is away
+func replyIfAway(sender *user, to *user) {
+	if to != sender && to.isAway() {
+		sender.writeln(devbot, to.awayNote())
+	}
+}
+
+// runIdleCheck marks users as away when they haven't typed anything in a while
+func runIdleCheck() {
+	for range time.Tick(30 * time.Second) {
+		idle := parseDurationOr(getConfig().IdleAfter, 0)
+		if idle <= 0 {
+			continue
+		}
+		for _, r := range allRooms() {
+			r.usersMutex.Lock()
+			for _, us := range r.users {
+				if !us.isAway() && !us.isSlack && time.Since(us.lastActive) > idle {
+					us.setAway("idle", true)
+					us.awaySince = us.lastActive
+				}
+			}
+			r.usersMutex.Unlock()
+		}
+	}
+}
+
+func awayCMD(rest string, u *user) {
+	if rest == "" {
+		rest = "away"
+	}
+	u.setAway(rest, false)
+	u.writeln(devbot, "You're now away: "+rest+". People who mention or DM you will be told. Use back when you're back.")
+}
+
+func backCMD(_ string, u *user) {
+	if !u.isAway() {
+		u.writeln(devbot, "You weren't away")
+		return
+	}
+	d := printPrettyDuration(time.Since(u.awaySince))
+	u.away = ""
+	u.autoAway = false
+	u.writeln(devbot, "Welcome back! You were away for "+d)
+}
@@ -43,6 +43,7 @@ var (
 	orange  = ansi256(5, 3, 0)
 	blue    = ansi256(0, 3, 5)
 	white   = ansi256(5, 5, 5)
+	gray    = ansi256(2, 2, 2)
 	styles  = []*style{
 		{"white", buildStyle(white)},
 		{"red", buildStyle(red)},
@@ -9,9 +9,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/acarl005/stripansi"
 	"github.com/alecthomas/chroma"
 	chromastyles "github.com/alecthomas/chroma/styles"
 	markdown "github.com/quackduck/go-term-markdown"
@@ -29,7 +29,7 @@ var (
 	allcmds = make([]cmd, 0, 30)
 	cmds    = []cmd{
 		{"=<user>", dmCMD, "<msg>", "DM <user> with <msg>"}, // won't actually run, here just to show in docs
-		{"users", usersCMD, "", "List users"},
+		{"users", usersCMD, "[status]", "List users, optionally with their status"},
 		{"color", colorCMD, "<color>", "Change your name's color"},
 		{"exit", exitCMD, "", "Leave the chat"},
 		{"help", helpCMD, "", "Show help"},
@@ -43,19 +43,55 @@ var (
 		{"tz", tzCMD, "<zone> [24h]", "Set your IANA timezone (like tz Asia/Dubai) and optionally set 24h"},
 		{"nick", nickCMD, "<name>", "Change your username"},
 		{"pronouns", pronounsCMD, "@user|pronouns", "Set your pronouns or get another user's"},
+		{"bio", bioCMD, "[set <text>|clear]", "Set or show your bio"},
+		{"status", statusCMD, "[set <text>|clear]", "Set or show your status line, like status set :palm_tree: on vacation"},
 		{"theme", themeCMD, "<theme>|list", "Change the syntax highlighting theme"},
+		{"edit", editCMD, "<msg id> <text>", "Edit one of your messages"},
+		{"delete", deleteCMD, "<msg id>", "Delete one of your messages"},
+		{"reply", replyCMD, "<msg id> <msg>", "Reply to a message"},
+		{"thread", threadCMD, "<msg id>", "Show a message and its replies"},
+		{"poll", pollCMD, `"question" <options...>|show|close`, "Start, show or close a poll"},
+		{"vote", voteCMD, "<poll id> <n>", "Vote in a poll"},
+		{"remind", remindCMD, "<me|#room> <in 30m|at 9:55|every ...> <msg>", "Set a reminder"},
+		{"reminders", remindersCMD, "list|cancel <id>", "Manage your reminders"},
+		{"away", awayCMD, "[msg]", "Mark yourself as away"},
+		{"back", backCMD, "", "Mark yourself as back"},
+		{"mentions", mentionsCMD, "[clear]", "See or clear messages that mentioned you"},
+		{"ignore", ignoreCMD, "<user>", "Hide everything from <user>"},
+		{"unignore", unignoreCMD, "<user>", "Stop ignoring <user>"},
+		{"ignored", ignoredCMD, "", "List who you're ignoring"},
+		{"follow", followCMD, "<user>", "Get told when <user> comes online, leaves or changes rooms"},
+		{"unfollow", unfollowCMD, "<user>", "Stop following <user>"},
+		{"following", followingCMD, "", "List who you follow and where they are"},
+		{"compose", composeCMD, "", "Write a multi-line message (Ctrl-D sends, Esc cancels)"},
+		{"tui", tuiCMD, "[login on|off]", "Switch to or from full-screen mode, or start in it when you connect"},
+		{"search", searchCMD, `[#room] <word|"words"> [filters]`, "Search messages (from:user before:date after:date dm:user)"},
+		{"react", reactCMD, "<msg id> :emoji:", "React to a message (again to take it back)"},
 		{"rest", commandsRestCMD, "", "Uncommon commands list"}}
 	cmdsRest = []cmd{
 		{"people", peopleCMD, "", "See info about nice people who joined"},
 		{"id", idCMD, "<user>", "Get a unique ID for a user (hashed key)"},
+		{"whois", whoisCMD, "<user>", "Show details about a user"},
+		{"seen", seenCMD, "<user>", "Find out when a user was last online"},
 		{"admins", adminsCMD, "", "Print the ID (hashed key) for all admins"},
 		{"eg-code", exampleCodeCMD, "[big]", "Example syntax-highlighted code"},
 		{"lsbans", listBansCMD, "", "List banned IDs"},
 		{"ban", banCMD, "<user>", "Ban <user> (admin)"},
 		{"unban", unbanCMD, "<IP|ID> [dur]", "Unban a person and optionally, for a duration (admin)"},
 		{"kick", kickCMD, "<user>", "Kick <user> (admin)"},
+		{"report", reportCMD, "<user> <reason>", "Privately report <user> to the moderators"},
+		{"reports", reportsCMD, "list|show|resolve <id>", "Review reports (admin/op)"},
+		{"export", exportCMD, "#room [from] [to] [format]", "Export a room's history as md, json or html (admin/op)"},
+		{"limits", limitsCMD, "", "Show rate limiter state (admin)"},
+		{"reload", reloadCMD, "", "Reload the config, admins, bans, art and filter (admin)"},
 		{"art", asciiArtCMD, "", "Show some panda art"},
 		{"pwd", pwdCMD, "", "Show your current room"},
+		{"room", roomCMD, "[setting]", "Show or change room settings: slow <dur>|off, readonly on|off, op|deop <user>, visibility <mode>, persist on|off, desc <text> (op)"},
+		{"invite", inviteCMD, "<user>", "Invite <user> to your room (op)"},
+		{"topic", topicCMD, "[text|clear]", "Show or set the room's topic (op to set)"},
+		{"pins", pinsCMD, "", "Show the room's pinned messages"},
+		{"pin", pinCMD, "<msg id>", "Pin a message in the room (op). Pins are kept across restarts only in persistent rooms and #main"},
+		{"unpin", unpinCMD, "<msg id>", "Unpin a message (op)"},
 		//		{"sixel", sixelCMD, "<png url>", "Render an image in high quality"},
 		{"shrug", shrugCMD, "", `¯\\\_(ツ)\_/¯`}} // won't actually run, here just to show in docs
 	secretCMDs = []cmd{
@@ -68,6 +104,7 @@ var (
 
 const (
 	maxLengthRoomName = 30
+	maxLengthName     = 27
 )
 
 func init() {
@@ -81,8 +118,8 @@ func init() {
 // It also accepts a boolean indicating if the line of input is from slack, in
 // which case some commands will not be run (such as ./tz and ./exit)
 func runCommands(line string, u *user) {
-	if detectBadWords(line) {
-		banUser("devbot [grow up]", u)
+	line, ok := applyFilter(line, u)
+	if !ok {
 		return
 	}
 
@@ -94,8 +131,12 @@ func runCommands(line string, u *user) {
 			mainRoom.broadcast(devbot, "Slap the developers in the face for me, the server almost crashed, also tell them this: "+fmt.Sprint(i, "\n"+string(debug.Stack())))
 		}
 	}()
-	currCmd := strings.Fields(line)[0]
-	if u.messaging != nil && currCmd != "=" && currCmd != "cd" && currCmd != "exit" && currCmd != "pwd" { // the commands allowed in a private dm room
+	first := strings.Fields(line)[0]
+	if u.isMuted() && isMessage(line, u) {
+		u.writeln(devbot, "You're muted for another "+printPrettyDuration(time.Until(u.mutedUntil)))
+		return
+	}
+	if u.messaging != nil && first != "=" && first != "cd" && first != "exit" && first != "pwd" { // the commands allowed in a private dm room
 		dmRoomCMD(line, u)
 		return
 	}
@@ -104,52 +145,213 @@ func runCommands(line string, u *user) {
 		return
 	}
 
-	switch currCmd {
-	case "hang":
-		hangCMD(strings.TrimSpace(strings.TrimPrefix(line, "hang")), u)
+	postErr := u.room.checkPost(u)
+	if postErr != "" && isMessage(line, u) {
+		u.writeln(devbot, postErr)
 		return
-	case "cd":
-		cdCMD(strings.TrimSpace(strings.TrimPrefix(line, "cd")), u)
-		return
-	case "shrug":
-		shrugCMD(strings.TrimSpace(strings.TrimPrefix(line, "shrug")), u)
+	}
+
+	currCmd := commandIn(line, u)
+	if cmdRules[currCmd].noEcho {
+		runCommand(currCmd, line, u)
 		return
 	}
 
-	if u.isSlack {
-		u.room.broadcastNoSlack(u.name, line)
-	} else {
-		u.room.broadcast(u.name, line)
+	if !u.isMuted() && postErr == "" { // muted users can still run commands, but what they type isn't shown
+		if currCmd == "" {
+			u.room.recordPost(u)
+		}
+		u.room.broadcastFrom(u, line)
 	}
 
 	devbotChat(u.room, line)
-
+	runCommand(currCmd, line, u)
+}
+
+// cmdRule changes how a command is run. Commands without one are echoed to the room and then run.
+type cmdRule struct {
+	noEcho bool                            // don't echo the line, because the command posts something itself or is private
+	posts  bool                            // the command posts what the user wrote, so it's held to the same rules as messages
+	parses func(rest string, u *user) bool // if set, lines it returns false for are posted as messages, so chat can start with the command's name
+}
+
+var cmdRules = map[string]cmdRule{
+	"hang":      {noEcho: true},
+	"cd":        {noEcho: true},
+	"edit":      {noEcho: true, parses: idThenText},
+	"delete":    {noEcho: true, parses: justID},
+	"reply":     {noEcho: true, posts: true, parses: idThenText},
+	"react":     {noEcho: true, parses: idThenEmoji},
+	"thread":    {noEcho: true, parses: justID},
+	"search":    {noEcho: true, parses: isSearch},
+	"poll":      {noEcho: true, parses: isPoll},
+	"vote":      {noEcho: true, parses: isVote},
+	"remind":    {noEcho: true, parses: isReminder},
+	"reminders": {noEcho: true, parses: oneOf("", "list", "cancel *")},
+	"away":      {noEcho: true},
+	"back":      {noEcho: true, parses: oneOf("")},
+	"mentions":  {noEcho: true, parses: oneOf("", "clear")},
+	"ignore":    {noEcho: true, parses: isKnownName},
+	"unignore":  {noEcho: true, parses: isKnownName},
+	"ignored":   {noEcho: true, parses: oneOf("")},
+	"follow":    {noEcho: true, parses: isKnownName},
+	"unfollow":  {noEcho: true, parses: isKnownName},
+	"following": {noEcho: true, parses: oneOf("")},
+	"whois":     {noEcho: true, parses: isKnownName},
+	"seen":      {noEcho: true, parses: isKnownName},
+	"bio":       {noEcho: true, parses: isProfileText},
+	"status":    {noEcho: true, parses: isProfileText},
+	"compose":   {noEcho: true, parses: oneOf("")},
+	"tui":       {noEcho: true, parses: oneOf("", "login on", "login off")},
+	"export":    {noEcho: true},
+	"report":    {noEcho: true}, // reports are private
+	"reports":   {noEcho: true},
+	"shrug":     {noEcho: true, posts: true},
+}
+
+// runCommand runs the command called name with the arguments in line, if there's one with that name
+func runCommand(name, line string, u *user) {
 	for _, c := range allcmds {
-		if c.name == currCmd {
-			c.run(strings.TrimSpace(strings.TrimPrefix(line, c.name)), u)
+		if c.name == name {
+			c.run(cmdArgs(line, name), u)
 			return
 		}
 	}
 }
 
-// TODO: replacing with asterisks could be faster
-func detectBadWords(text string) bool {
-	text = strings.ToLower(text)
-	badWords := []string{"nigger", "faggot", "tranny", "trannies"} // TODO: add more, it's sad that this is necessary, but the internet is harsh
-	badWKillWords := []string{"tranny", "trannies", "transgender", "gay", "muslim", "jew"}
-	for _, word := range badWords {
-		if strings.Contains(text, word) {
+// commandIn returns the name of the command a line runs, or an empty string if the line is a message
+func commandIn(line string, u *user) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !isCommand(fields[0]) {
+		return ""
+	}
+	if rule := cmdRules[fields[0]]; rule.parses != nil && !rule.parses(cmdArgs(line, fields[0]), u) {
+		return ""
+	}
+	return fields[0]
+}
+
+// cmdArgs returns what comes after the command's name in a line
+func cmdArgs(line, name string) string {
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), name))
+}
+
+// isCommand reports if name is the name of a command
+func isCommand(name string) bool {
+	for _, c := range allcmds {
+		if c.name == name {
 			return true
 		}
 	}
-	for _, word := range badWKillWords {
-		if strings.Contains(text, word) && (strings.Contains(text, "kill") || strings.Contains(text, "death") || strings.Contains(text, "dead") || strings.Contains(text, "murder")) {
-			return true
+	return false
+}
+
+// isMessage reports if a line posts a message in the room, either because it isn't a command or because
+// it's a command that posts what the user wrote.
+func isMessage(line string, u *user) bool {
+	c := commandIn(line, u)
+	return c == "" || cmdRules[c].posts
+}
+
+// oneOf makes a parser that accepts only the forms given. A form ending in " *" accepts one more word.
+func oneOf(forms ...string) func(rest string, u *user) bool {
+	return func(rest string, _ *user) bool {
+		for _, f := range forms {
+			if rest == f || (strings.HasSuffix(f, " *") && len(strings.Fields(rest)) == 2 && strings.Fields(rest)[0] == strings.TrimSuffix(f, " *")) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// isMessageID reports if s is the ID of a message in u's room, like ab2c or [ab2c]
+func isMessageID(s string, u *user) bool {
+	_, ok := u.room.findMessage(s)
+	return ok
+}
+
+// the parsers below accept an empty line too, so the command can show how it's used
+
+func justID(rest string, u *user) bool {
+	args := strings.Fields(rest)
+	return len(args) == 0 || (len(args) == 1 && isMessageID(args[0], u))
+}
+
+func idThenText(rest string, u *user) bool {
+	args := strings.Fields(rest)
+	return len(args) == 0 || (len(args) >= 2 && isMessageID(args[0], u))
+}
+
+func idThenEmoji(rest string, u *user) bool {
+	args := strings.Fields(rest)
+	return len(args) == 0 || (len(args) == 2 && isEmoji(args[1]) && isMessageID(args[0], u))
+}
+
+// isSearch accepts one word, or a query with a quoted phrase, a room or a filter like from:bob
+func isSearch(rest string, _ *user) bool {
+	args := strings.Fields(rest)
+	if len(args) <= 1 || strings.HasPrefix(rest, "#") || strings.Contains(rest, `"`) {
+		return true
+	}
+	for _, a := range args {
+		if i := strings.Index(a, ":"); i > 0 {
+			switch strings.ToLower(a[:i]) {
+			case "from", "before", "after", "dm", "in":
+				return true
+			}
 		}
 	}
 	return false
 }
 
+// isProfileText accepts the forms of bio and status, which need "set" so chat like "status is green" isn't taken as one
+func isProfileText(rest string, _ *user) bool {
+	return rest == "" || rest == "clear" || strings.HasPrefix(rest, "set ")
+}
+
+func isPoll(rest string, _ *user) bool {
+	args := strings.Fields(rest)
+	return len(args) == 0 || strings.HasPrefix(rest, `"`) || ((args[0] == "show" || args[0] == "close") && len(args) <= 2)
+}
+
+func isVote(rest string, _ *user) bool {
+	args := strings.Fields(rest)
+	if len(args) == 0 {
+		return true
+	}
+	if len(args) != 2 {
+		return false
+	}
+	_, err1 := strconv.Atoi(args[0])
+	_, err2 := strconv.Atoi(args[1])
+	return err1 == nil && err2 == nil
+}
+
+func isReminder(rest string, _ *user) bool {
+	if rest == "" {
+		return true
+	}
+	_, err := parseReminder(rest, time.UTC)
+	return err == nil
+}
+
+// isKnownName accepts the name of someone online, or someone who's been online before
+func isKnownName(rest string, _ *user) bool {
+	if rest == "" {
+		return true
+	}
+	if len(strings.Fields(rest)) != 1 {
+		return false
+	}
+	name := strings.TrimPrefix(rest, "@")
+	if _, ok := findUserByNameAnywhere(name); ok {
+		return true
+	}
+	_, ok := findSession(name)
+	return ok
+}
+
 func dmCMD(rest string, u *user) {
 	restSplit := strings.Fields(rest)
 	if len(restSplit) < 2 {
@@ -170,7 +372,11 @@ func dmCMD(rest string, u *user) {
 			"what an idiot"}, 30)
 		return
 	}
-	peer.writeln(u.name+" -> ", msg)
+	recordDM(u, peer, msg)
+	if !peer.isIgnoring(u.id) { // the sender isn't told they're being ignored
+		peer.writeln(u.name+" -> ", msg)
+		replyIfAway(u, peer)
+	}
 }
 
 func hangCMD(rest string, u *user) {
@@ -184,8 +390,17 @@ func hangCMD(rest string, u *user) {
 		u.room.broadcast(devbot, "```\n"+hangPrint(hangGame)+"\nTries: "+strconv.Itoa(hangGame.triesLeft)+"\n```")
 		return
 	}
+	if u.isMuted() {
+		u.writeln(devbot, "You can't play while you're muted")
+		return
+	}
+	if msg := u.room.checkPost(u); msg != "" { // guesses are shown in the room
+		u.writeln(devbot, msg)
+		return
+	}
+	u.room.recordPost(u)
 	if !u.isSlack {
-		u.room.broadcast(u.name, "hang "+rest)
+		u.room.broadcastFrom(u, "hang "+rest)
 	}
 	if strings.Trim(hangGame.word, hangGame.guesses) == "" {
 		u.room.broadcast(devbot, "The game has ended. Start a new game with hang <word>")
@@ -217,10 +432,19 @@ func hangCMD(rest string, u *user) {
 }
 
 func clearCMD(_ string, u *user) {
+	u.forgetScrollback()
+	if u.fullScreen() != nil {
+		u.scheduleDraw()
+		return
+	}
 	u.term.Write([]byte("\033[H\033[2J"))
 }
 
-func usersCMD(_ string, u *user) {
+func usersCMD(rest string, u *user) {
+	if rest == "-s" || rest == "status" {
+		u.room.broadcast("", printStatusesInRoom(u.room))
+		return
+	}
 	u.room.broadcast("", printUsersInRoom(u.room))
 }
 
@@ -233,7 +457,11 @@ func dmRoomCMD(line string, u *user) {
 			"what an idiot"}, 30)
 		return
 	}
-	u.messaging.writeln(u.name+" -> ", line)
+	recordDM(u, u.messaging, line)
+	if !u.messaging.isIgnoring(u.id) {
+		u.messaging.writeln(u.name+" -> ", line)
+		replyIfAway(u, u.messaging)
+	}
 }
 
 func ticCMD(rest string, u *user) {
@@ -311,42 +539,70 @@ func cdCMD(rest string, u *user) {
 		}
 	}
 	if rest == ".." { // cd back into the main room
-		u.room.broadcast(u.name, "cd "+rest)
+		u.room.broadcastFrom(u, "cd "+rest)
 		if u.room != mainRoom {
 			u.changeRoom(mainRoom)
 		}
 		return
 	}
 	if strings.HasPrefix(rest, "#") {
-		u.room.broadcast(u.name, "cd "+rest)
-		if len(rest) > maxLengthRoomName {
-			rest = rest[0:maxLengthRoomName]
+		if strings.Contains(rest, "/") {
+			u.writeln(devbot, "Room names can't contain /")
+			return
+		}
+		name := rest
+		if len(name) > maxLengthRoomName {
+			name = name[0:maxLengthRoomName]
+		}
+		v, ok := getRoom(name)
+		if !ok || !v.isHidden() { // don't give away the names of hidden rooms
+			u.room.broadcastFrom(u, "cd "+rest)
+		}
+		if name != rest {
+			rest = name
 			u.room.broadcast(devbot, "Room name lengths are limited, so I'm shortening it to "+rest+".")
 		}
-		if v, ok := rooms[rest]; ok {
-			u.changeRoom(v)
+		if ok {
+			if v.admit(u) {
+				u.changeRoom(v)
+			}
 		} else {
-			rooms[rest] = &room{rest, make([]*user, 0, 10), sync.Mutex{}}
-			u.changeRoom(rooms[rest])
+			r := newRoom(rest)
+			r.owner = u.id
+			r.ownerName = stripansi.Strip(u.name)
+			if existing := addRoom(r); existing != r { // someone else just made it
+				if existing.admit(u) {
+					u.changeRoom(existing)
+				}
+				return
+			}
+			u.changeRoom(r)
 		}
 		return
 	}
 	if rest == "" {
-		u.room.broadcast(u.name, "cd "+rest)
+		u.room.broadcastFrom(u, "cd "+rest)
 		type kv struct {
-			roomName   string
+			room       *room
 			numOfUsers int
 		}
 		var ss []kv
-		for k, v := range rooms {
-			ss = append(ss, kv{k, len(v.users)})
+		for _, v := range allRooms() {
+			if v.isHidden() && v != u.room { // the listing is shown to everyone in the room
+				continue
+			}
+			ss = append(ss, kv{v, len(v.users)})
 		}
 		sort.Slice(ss, func(i, j int) bool {
 			return ss[i].numOfUsers > ss[j].numOfUsers
 		})
 		roomsInfo := ""
 		for _, kv := range ss {
-			roomsInfo += blue.Paint(kv.roomName) + ": " + printUsersInRoom(rooms[kv.roomName]) + "  \n"
+			roomsInfo += blue.Paint(kv.room.name)
+			if topic := kv.room.topic; topic != "" {
+				roomsInfo += " (" + topic + ")"
+			}
+			roomsInfo += ": " + printUsersInRoom(kv.room) + "  \n"
 		}
 		u.room.broadcast("", "Rooms and users  \n"+strings.TrimSpace(roomsInfo))
 		return
@@ -411,9 +667,11 @@ func nickCMD(line string, u *user) {
 
 func listBansCMD(_ string, u *user) {
 	msg := "Printing bans by ID:  \n"
+	bansMutex.Lock()
 	for i := 0; i < len(bans); i++ {
 		msg += cyan.Cyan(strconv.Itoa(i+1)) + ". " + bans[i].ID + "  \n"
 	}
+	bansMutex.Unlock()
 	u.room.broadcast(devbot, msg)
 }
 
@@ -434,6 +692,8 @@ func unbanCMD(toUnban string, u *user) {
 // unbanIDorIP unbans an ID or an IP, but does NOT save bans to the bans file.
 // It returns whether the person was found, and so, whether the bans slice was modified.
 func unbanIDorIP(toUnban string) bool {
+	bansMutex.Lock()
+	defer bansMutex.Unlock()
 	for i := 0; i < len(bans); i++ {
 		if bans[i].ID == toUnban || bans[i].Addr == toUnban { // allow unbanning by either ID or IP
 			// remove this ban
@@ -466,7 +726,7 @@ func banCMD(line string, u *user) {
 			u.room.broadcast(devbot, "I couldn't parse that as a duration")
 			return
 		}
-		bans = append(bans, ban{victim.addr, victim.id})
+		addBan(victim.addr, victim.id)
 		victim.close(victim.name + " has been banned by " + u.name + " for " + dur.String())
 		go func(id string) {
 			time.Sleep(dur)
@@ -478,7 +738,7 @@ func banCMD(line string, u *user) {
 }
 
 func banUser(banner string, victim *user) {
-	bans = append(bans, ban{victim.addr, victim.id})
+	addBan(victim.addr, victim.id)
 	saveBans()
 	victim.close(victim.name + " has been banned by " + banner)
 }
@@ -506,6 +766,7 @@ func colorCMD(rest string, u *user) {
 
 func adminsCMD(_ string, u *user) {
 	msg := "Admins:  \n"
+	admins := getSettings().admins
 	for i := range admins {
 		msg += admins[i] + ": " + i + "  \n"
 	}
@@ -558,14 +819,14 @@ Run cmds to see a list of commands.
 
 Interesting features:
 * Rooms! Run cd to see all rooms and use cd #foo to join a new room.
-* Markdown support! Tables, headers, italics and everything. Just use \\n in place of newlines.
+* Markdown support! Tables, headers, italics and everything. Run compose to write a multi-line message, or use \\n in place of newlines.
 * Code syntax highlighting. Use Markdown fences to send code. Run eg-code to see an example.
 * Direct messages! Send a quick DM using =user <msg> or stay in DMs by running cd @user.
 * Timezone support, use tz Continent/City to set your timezone.
 * Built in Tic Tac Toe and Hangman! Run tic or hang <word> to start new games.
 * Emoji replacements! \:rocket\: => :rocket: (like on Slack and Discord)
-
-For replacing newlines, I often use bulkseotools.com/add-remove-line-breaks.php.
+* Press up for what you sent before (it's kept between sessions), Ctrl-R to search it and tab to complete commands, users and rooms.
+* Full-screen mode with a room list, unread counts and a user list. Run tui, or connect with ssh -t <host> tui.
 
 Join the Devzat discord server: https://discord.gg/5AUjJvBHeT
 
@@ -659,7 +920,7 @@ func themeCMD(line string, u *user) {
 }
 
 func asciiArtCMD(_ string, u *user) {
-	u.room.broadcast("", art)
+	u.room.broadcast("", getSettings().art)
 }
 
 func pwdCMD(_ string, u *user) {
@@ -672,7 +933,8 @@ func pwdCMD(_ string, u *user) {
 }
 
 func shrugCMD(line string, u *user) {
-	u.room.broadcast(u.name, line+` ¯\\\_(ツ)\_/¯`)
+	u.room.recordPost(u)
+	u.room.broadcastFrom(u, line+` ¯\\\_(ツ)\_/¯`)
 }
 
 func pronounsCMD(line string, u *user) {
@@ -723,7 +985,7 @@ func manCMD(rest string, u *user) {
 
 func lsCMD(rest string, u *user) {
 	if len(rest) > 0 && rest[0] == '#' {
-		if r, ok := rooms[rest]; ok {
+		if r, ok := getRoom(rest); ok && (!r.isHidden() || r == u.room) {
 			usersList := ""
 			for _, us := range r.users {
 				usersList += us.name + blue.Paint("/ ")
@@ -737,7 +999,10 @@ func lsCMD(rest string, u *user) {
 		return
 	}
 	roomList := ""
-	for _, r := range rooms {
+	for _, r := range allRooms() {
+		if r.isHidden() && r != u.room {
+			continue
+		}
 		roomList += blue.Paint(r.name + "/ ")
 	}
 	usersList := ""
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gliderlabs/ssh"
+	terminal "github.com/quackduck/term"
+)
+
+const (
+	keyCtrlC  = 3
+	keyCtrlD  = 4
+	keyCtrlN  = 14
+	keyCtrlP  = 16
+	keyCtrlR  = 18
+	keyEscape = 27
+
+	// the terminal handles up and down itself and ignores page up and down, so they're turned into these private runes, which it passes to autocompleteCallback
+	keyHistoryUp   = '\uE000'
+	keyHistoryDown = '\uE001'
+	keyPageUp      = '\uE002'
+	keyPageDown    = '\uE003'
+)
+
+type composeAction int
+
+const (
+	composeNone composeAction = iota
+	composeSend
+	composeCancel
+)
+
+var (
+	upKeys   = [][]byte{[]byte("\x1b[A"), []byte("\x1bOA"), {keyCtrlP}}
+	downKeys = [][]byte{[]byte("\x1b[B"), []byte("\x1bOB"), {keyCtrlN}}
+	pgUpKeys = [][]byte{[]byte("\x1b[5~")}
+	pgDnKeys = [][]byte{[]byte("\x1b[6~")}
+	pasting  = []byte("\x1b[200~")
+)
+
+// keyReader reads keys from a session for the terminal, catching keys the terminal doesn't tell us about
+type keyReader struct {
+	ssh.Session
+	u       *user
+	buf     [256]byte
+	pending []byte // translated input that didn't fit in the last Read
+}
+
+func (k *keyReader) Read(p []byte) (int, error) {
+	if len(k.pending) == 0 {
+		n, err := k.Session.Read(k.buf[:])
+		if n == 0 {
+			return 0, err
+		}
+		if k.u == nil { // still logging in
+			k.pending = append(k.pending[:0], k.buf[:n]...)
+		} else {
+			k.pending = k.translate(k.buf[:n])
+		}
+	}
+	n := copy(p, k.pending)
+	k.pending = k.pending[n:]
+	return n, nil
+}
+
+// translate turns up and down into history keys and page up and down into scrolling keys, and handles keys for compose mode
+func (k *keyReader) translate(in []byte) []byte {
+	if k.u.composing {
+		// The terminal returns io.EOF for Ctrl-C and for Ctrl-D only on an empty line, and swallows a lone Esc.
+		// While composing, turn these into an Enter so ReadLine returns the current line, and remember what to do.
+		if len(in) == 1 && in[0] == keyEscape { // escape sequences like arrow keys arrive together, so this was the Esc key
+			k.u.composeAction = composeCancel
+			return []byte{'\r'}
+		}
+		for i, b := range in {
+			switch b {
+			case keyCtrlD:
+				k.u.composeAction = composeSend
+			case keyCtrlC:
+				k.u.composeAction = composeCancel
+			default:
+				continue
+			}
+			return append(append([]byte{}, in[:i]...), '\r') // drop anything typed after
+		}
+		return append([]byte{}, in...)
+	}
+	if bytes.Contains(in, pasting) {
+		return append([]byte{}, in...)
+	}
+	out := make([]byte, 0, len(in)+8)
+	for len(in) > 0 {
+		if rest, ok := cutKey(in, upKeys); ok {
+			out, in = appendRune(out, keyHistoryUp), rest
+			continue
+		}
+		if rest, ok := cutKey(in, downKeys); ok {
+			out, in = appendRune(out, keyHistoryDown), rest
+			continue
+		}
+		if rest, ok := cutKey(in, pgUpKeys); ok {
+			out, in = appendRune(out, keyPageUp), rest
+			continue
+		}
+		if rest, ok := cutKey(in, pgDnKeys); ok {
+			out, in = appendRune(out, keyPageDown), rest
+			continue
+		}
+		out, in = append(out, in[0]), in[1:]
+	}
+	return out
+}
+
+// cutKey reports if b starts with one of keys, returning what's after it
+func cutKey(b []byte, keys [][]byte) ([]byte, bool) {
+	for _, key := range keys {
+		if bytes.HasPrefix(b, key) {
+			return b[len(key):], true
+		}
+	}
+	return b, false
+}
+
+func appendRune(b []byte, r rune) []byte {
+	var enc [utf8.UTFMax]byte
+	return append(b, enc[:utf8.EncodeRune(enc[:], r)]...)
+}
+
+func composePrompt(lineNum int) string {
+	return gray.Paint(fmt.Sprintf("%3d│ ", lineNum))
+}
+
+func composeCMD(_ string, u *user) {
+	if u.isSlack {
+		return
+	}
+	u.writeln(devbot, "Composing a message. Press Ctrl-D to send or Esc to cancel.  \n"+
+		"Type /preview on its own line to see how it'll look, or /undo to remove the last line.")
+	lines := make([]string, 0, 10)
+	shown := make([]string, 0, 10) // what's on screen, so it can be erased
+	u.composing = true
+	u.composeAction = composeNone
+	defer func() {
+		u.composing = false
+		u.term.SetPrompt(u.name + ": ")
+	}()
+	for {
+		u.term.SetPrompt(composePrompt(len(lines) + 1))
+		line, err := u.term.ReadLine()
+		if err != nil && err != terminal.ErrPasteIndicator {
+			return // the next read in repl will fail too and close the connection
+		}
+		shown = append(shown, composePrompt(len(lines)+1)+line)
+		action := u.composeAction
+		u.composeAction = composeNone
+		if action == composeCancel {
+			u.eraseLines(linesTaken(strings.Join(shown, "\n"), u.size().Width))
+			u.writeln(devbot, "Cancelled your message")
+			return
+		}
+		switch strings.TrimSpace(line) {
+		case "/preview":
+			u.writeln(u.name, joinComposed(lines))
+			shown = shown[:0] // don't erase past the preview
+			continue
+		case "/undo":
+			if len(lines) > 0 {
+				lines = lines[:len(lines)-1]
+				u.writeln(devbot, "Removed line "+fmt.Sprint(len(lines)+1))
+				shown = shown[:0]
+			}
+			continue
+		}
+		if action != composeSend || line != "" {
+			lines = append(lines, line)
+		}
+		if action == composeSend {
+			break
+		}
+	}
+	u.eraseLines(linesTaken(strings.Join(shown, "\n"), u.size().Width))
+	text := strings.TrimSpace(joinComposed(lines))
+	if text == "" {
+		u.writeln(devbot, "Not sending an empty message")
+		return
+	}
+	if len(text) > maxMsgLen {
+		text = text[:maxMsgLen]
+	}
+	u.composing = false
+	if allowMessage(u, text) {
+		runCommands(text, u)
+	}
+}
+
+// joinComposed joins the lines of a composed message. A \n someone typed is escaped so it isn't shown as a line break.
+func joinComposed(lines []string) string {
+	escaped := make([]string, len(lines))
+	for i, line := range lines {
+		escaped[i] = strings.ReplaceAll(line, `\n`, `\\n`)
+	}
+	return strings.Join(escaped, "\n")
+}
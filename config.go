@@ -5,33 +5,81 @@ import (
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
+	"sync/atomic"
 )
 
 type config struct {
 	SSHPort     int `yaml:"ssh_port"`
 	ProfilePort int `yaml:"profile_port"`
+	ExportPort  int `yaml:"export_port"` // exports can be downloaded from here. 0 to disable downloads.
 
 	DataDir   string `yaml:"data_dir"`
 	KeyFile   string `yaml:"key_file"`
 	CredsFile string `yaml:"creds_file"`
+
+	FilterFile string `yaml:"filter_file"` // content filter rules, reloaded on SIGHUP
+
+	IdleAfter string `yaml:"idle_after"` // users are marked away after this long without typing anything. Empty to disable.
+
+	RateLimits rateLimitConfig `yaml:"rate_limits"`
 }
 
 var (
 	// TODO: use this config!!
 
-	Config = config{ // first stores default config
-		2221,
-		5555,
-		"./devzat-data",
-		"./devzat-sshkey",
-		"./devzat-creds.json",
+	defaultConfig = config{
+		SSHPort:     2221,
+		ProfilePort: 5555,
+		ExportPort:  5556,
+
+		DataDir:   "./devzat-data",
+		KeyFile:   "./devzat-sshkey",
+		CredsFile: "./devzat-creds.json",
+
+		FilterFile: "./devzat-filter.yml",
+
+		IdleAfter: "15m",
+
+		RateLimits: rateLimitConfig{
+			UserMessages: rateLimit{Rate: 3, Burst: 15},
+			IPMessages:   rateLimit{Rate: 5, Burst: 25},
+			RoomMessages: rateLimit{Rate: 15, Burst: 60},
+			UserLogins:   rateLimit{Rate: 0.1, Burst: 6},
+			IPLogins:     rateLimit{Rate: 0.2, Burst: 10},
+
+			StrikesToMute: 3,
+			StrikesToBan:  6,
+			MuteFor:       "1m",
+			StrikesExpire: "10m",
+		},
 	}
+
+	configFile = os.Getenv("DEVZAT_CONFIG")
+
+	currentSettings atomic.Value // a *settings. Use getSettings and getConfig.
 )
 
+// settings is everything reload replaces. It's swapped in as a whole so nothing sees half of a reload.
+type settings struct {
+	config      config
+	admins      map[string]string // ID to info
+	art         string
+	filterRules []filterRule
+}
+
+// getSettings returns the settings in use. They're shared, so don't modify them.
+func getSettings() *settings {
+	return currentSettings.Load().(*settings)
+}
+
+// getConfig returns the config in use
+func getConfig() config {
+	return getSettings().config
+}
+
 func init() {
-	cfgFile := os.Getenv("DEVZAT_CONFIG")
-	if cfgFile == "" {
-		cfgFile = "devzat-config.yml"
+	if configFile == "" {
+		configFile = "devzat-config.yml"
 	}
 
 	errCheck := func(err error) {
@@ -41,21 +89,38 @@ func init() {
 		}
 	}
 
-	if _, err := os.Stat(cfgFile); err != nil {
-		if os.IsNotExist(err) {
-			fmt.Println("Config file not found, so writing the default one to " + cfgFile)
-
-			d, err := yaml.Marshal(Config)
-			errCheck(err)
-			err = os.WriteFile(cfgFile, d, 0644)
+	c := defaultConfig
+	if _, err := os.Stat(configFile); err != nil {
+		if !os.IsNotExist(err) {
 			errCheck(err)
-			return
 		}
+		fmt.Println("Config file not found, so writing the default one to " + configFile)
+
+		d, err := yaml.Marshal(defaultConfig)
+		errCheck(err)
+		err = os.WriteFile(configFile, d, 0644)
+		errCheck(err)
+	} else {
+		c, err = loadConfig(configFile)
 		errCheck(err)
+		fmt.Println("Config loaded from "+configFile, c)
+	}
+	currentSettings.Store(&settings{
+		config:      c,
+		admins:      getAdmins(),
+		art:         getASCIIArt(),
+		filterRules: mustCompileFilterRules(defaultFilterRules), // replaced by the filter file in filter.go's init
+	})
+}
+
+// loadConfig reads a config file, using the default config for any missing values.
+// The config in use is not modified.
+func loadConfig(file string) (config, error) {
+	c := defaultConfig
+	d, err := ioutil.ReadFile(file)
+	if err != nil {
+		return c, err
 	}
-	d, err := ioutil.ReadFile(cfgFile)
-	errCheck(err)
-	err = yaml.Unmarshal(d, &Config)
-	errCheck(err)
-	fmt.Println("Config loaded from "+cfgFile, Config)
+	err = yaml.Unmarshal(d, &c)
+	return c, err
 }
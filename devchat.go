@@ -2,11 +2,9 @@ package main
 
 import (
 	_ "embed"
-	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"math/rand"
 	"net"
 	"net/http"
@@ -28,17 +26,17 @@ import (
 var (
 	port        = 22
 	scrollback  = 16
+	roomHistory = 500 // how many messages each room keeps so they can be referred to by ID
 	profilePort = 5555
 	// should this instance run offline? (should it not connect to slack or twitter?)
 	offlineSlack   = os.Getenv("DEVZAT_OFFLINE_SLACK") != ""
 	offlineTwitter = os.Getenv("DEVZAT_OFFLINE_TWITTER") != ""
 
-	mainRoom         = &room{"#main", make([]*user, 0, 10), sync.Mutex{}}
-	rooms            = map[string]*room{mainRoom.name: mainRoom}
-	backlog          = make([]backlogMessage, 0, scrollback)
-	bans             = make([]ban, 0, 10)
-	idsInMinToTimes  = make(map[string]int, 10) // TODO: maybe add some IP-based factor to disallow rapid key-gen attempts
-	antispamMessages = make(map[string]int)
+	mainRoom   = newRoom("#main")
+	rooms      = map[string]*room{mainRoom.name: mainRoom}
+	roomsMutex sync.Mutex // guards rooms. Use getRoom and allRooms instead of using it directly.
+	bans       = make([]ban, 0, 10)
+	bansMutex  sync.Mutex // guards bans
 
 	logfile, _  = os.OpenFile("log.txt", os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0666)
 	l           = log.New(io.MultiWriter(logfile, os.Stdout), "", log.Ldate|log.Ltime|log.Lshortfile)
@@ -57,8 +55,29 @@ type ban struct {
 
 type room struct {
 	name       string
+	key        string // unique to this room, so its history isn't mixed up with other rooms that had the same name
 	users      []*user
 	usersMutex sync.Mutex
+
+	ops      map[string]string // IDs of users who can moderate this room, to their names
+	slowMode time.Duration     // how long users must wait between posts, if set
+	readOnly bool              // if set, only ops can post
+	lastPost map[string]time.Time
+
+	backlog []backlogMessage // the last few messages sent in this room
+
+	owner        string // ID of the user who made the room
+	ownerName    string
+	visibility   string
+	allowed      map[string]bool // IDs of users who were invited or know the password
+	passwordSalt string
+	passwordHash string
+
+	persistent  bool // if set, the room is kept when empty and across restarts
+	topic       string
+	description string
+	created     time.Time
+	pins        []pinnedMessage
 }
 
 type user struct {
@@ -80,17 +99,49 @@ type user struct {
 	id      string
 	addr    string
 
-	win           ssh.Window
+	win           ssh.Window // use size() to read it
 	closeOnce     sync.Once
 	lastTimestamp time.Time
 	joinTime      time.Time
 	timezone      *time.Location
+	mutedUntil    time.Time
+
+	away       string // the user's away message, if they're away
+	awaySince  time.Time
+	autoAway   bool // set if the user was marked away for being idle
+	lastActive time.Time
+
+	composing     bool // set while the user is writing a multi-line message
+	composeAction composeAction
+
+	history             []string // lines the user sent, oldest first
+	historyIndex        int      // the entry being shown while browsing with up and down, or -1
+	historyDraft        string   // what was typed before browsing
+	historySearch       string   // the Ctrl-R search
+	historySearchFrom   int      // where the next Ctrl-R looks from, or -1
+	historySearchResult string
+
+	scrollback      []printed // recent output, so it can be redrawn when the window is resized
+	scrollbackMutex sync.Mutex
+	tui             *tuiState  // set if the user is in full-screen mode
+	screenMutex     sync.Mutex // guards win and tui, which are changed while other goroutines write to the user
 }
 
 type backlogMessage struct {
+	id         string // short ID that people can use to refer to the message. Only set if there's a sender.
 	timestamp  time.Time
 	senderName string
+	senderID   string
 	text       string
+	edited     bool
+	deleted    bool
+	replyTo    string // ID of the message this is a reply to
+	reactions  []reaction
+}
+
+type reaction struct {
+	emoji   string
+	userIDs []string
 }
 
 // TODO: have a web dashboard that shows logs
@@ -101,15 +152,37 @@ func main() {
 			l.Println(err)
 		}
 	}()
+	if getConfig().ExportPort != 0 {
+		go serveExports(getConfig().ExportPort)
+	}
 	devbot = green.Paint("devbot")
 	rand.Seed(time.Now().Unix())
 	readBans()
+	loadHistory()
+	go runReminders()
+	go runIdleCheck()
+	go runProfileSaves()
+	go runMentionSaves()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadAndReport("SIGHUP")
+		}
+	}()
 	c := make(chan os.Signal, 2)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
 		fmt.Println("Shutting down...")
 		saveBans()
+		mentionsMutex.Lock()
+		saveMentions()
+		mentionsMutex.Unlock()
+		for _, us := range onlineUsers() {
+			us.saveInputHistory()
+		}
+		saveProfiles() // don't wait for runProfileSaves
 		logfile.Close()
 		time.AfterFunc(time.Second, func() {
 			l.Println("Broadcast taking too long, exiting server early.")
@@ -167,7 +240,7 @@ func main() {
 }
 
 func universeBroadcast(senderName, msg string) {
-	for _, r := range rooms {
+	for _, r := range allRooms() {
 		r.broadcast(senderName, msg)
 	}
 }
@@ -177,36 +250,117 @@ func (r *room) broadcast(senderName, msg string) {
 		return
 	}
 	if senderName != "" {
-		slackChan <- "[" + r.name + "] " + senderName + ": " + msg
+		r.sendToSlack(senderName + ": " + msg)
 	} else {
-		slackChan <- "[" + r.name + "] " + msg
+		r.sendToSlack(msg)
 	}
 	r.broadcastNoSlack(senderName, msg)
 }
 
+// sendToSlack mirrors a message in the room to Slack, unless the room is hidden
+func (r *room) sendToSlack(msg string) {
+	if !r.isHidden() {
+		slackChan <- "[" + r.name + "] " + msg
+	}
+}
+
 func (r *room) broadcastNoSlack(senderName, msg string) {
-	if msg == "" {
+	r.post(&backlogMessage{senderName: senderName, text: msg})
+}
+
+// broadcastFrom sends a message from a user to the room (and Slack, if the user isn't on Slack and the room isn't hidden)
+func (r *room) broadcastFrom(u *user, msg string) {
+	r.postFrom(u, &backlogMessage{text: msg})
+}
+
+// postFrom is like broadcastFrom but takes a message, filling in the sender
+func (r *room) postFrom(u *user, m *backlogMessage) {
+	if m.text == "" {
 		return
 	}
-	msg = strings.ReplaceAll(msg, "@everyone", green.Paint("everyone\a"))
+	if !u.isSlack {
+		r.sendToSlack(u.name + ": " + m.text)
+	}
+	m.senderName = u.name
+	m.senderID = u.id
+	for _, us := range r.post(m) {
+		replyIfAway(u, us)
+	}
+}
+
+// post sends a message to everyone in the room and adds it to the backlog, giving it an ID if it has a sender.
+// It returns the online users mentioned in the message. Offline users who were mentioned get it in their inbox.
+func (r *room) post(m *backlogMessage) []*user {
+	if m.text == "" {
+		return nil
+	}
+	var mentioned []mentionable
+	m.text, mentioned = expandMentions(m.text) // before locking r, since this locks every room
+	isMentioned := make(map[*user]bool, len(mentioned))
+	online := make([]*user, 0, len(mentioned))
+	for _, p := range mentioned {
+		if p.u != nil {
+			isMentioned[p.u] = true
+			online = append(online, p.u)
+		}
+	}
 	r.usersMutex.Lock()
-	for i := range r.users {
-		msg = strings.ReplaceAll(msg, "@"+stripansi.Strip(r.users[i].name), r.users[i].name)
-		msg = strings.ReplaceAll(msg, `\`+r.users[i].name, "@"+stripansi.Strip(r.users[i].name)) // allow escaping
+	m.timestamp = time.Now()
+	if m.senderName != "" {
+		m.id = r.newMessageID()
 	}
+	quote := r.quoteOf(m)
 	for i := range r.users {
-		r.users[i].writeln(senderName, msg)
+		r.users[i].writeMessage(m, quote)
+		if isMentioned[r.users[i]] && !r.users[i].isIgnoring(m.senderID) {
+			r.users[i].ring()
+		}
+	}
+	if m.id != "" {
+		recordRoomMessage(r, m)
+	}
+	m.text += "\n"
+	r.backlog = append(r.backlog, *m)
+	if len(r.backlog) > roomHistory {
+		r.backlog = r.backlog[len(r.backlog)-roomHistory:]
 	}
 	r.usersMutex.Unlock()
-	if r == mainRoom {
-		backlog = append(backlog, backlogMessage{time.Now(), senderName, msg + "\n"})
-		if len(backlog) > scrollback {
-			backlog = backlog[len(backlog)-scrollback:]
+	if m.id != "" {
+		for _, p := range mentioned {
+			notifyMention(r, m, p)
 		}
+		countUnread(r, m)
 	}
+	return online
+}
+
+// getBacklog returns a copy of the last scrollback messages in the room's backlog
+func (r *room) getBacklog() []backlogMessage {
+	r.usersMutex.Lock()
+	defer r.usersMutex.Unlock()
+	start := len(r.backlog) - scrollback
+	if start < 0 {
+		start = 0
+	}
+	return append(make([]backlogMessage, 0, len(r.backlog)-start), r.backlog[start:]...)
 }
 
 func autocompleteCallback(u *user, line string, pos int, key rune) (string, int, bool) {
+	switch key {
+	case keyHistoryUp, keyHistoryDown:
+		if u.composing {
+			return line, pos, true
+		}
+		return u.browseHistory(line, key == keyHistoryUp)
+	case keyCtrlR:
+		if u.composing {
+			return line, pos, true
+		}
+		return u.searchHistory(line)
+	case keyPageUp, keyPageDown:
+		u.scrollTUI(key == keyPageUp)
+		return line, pos, true
+	}
 	if key == '\t' {
 		// Autocomplete a username
 
@@ -222,7 +376,7 @@ func autocompleteCallback(u *user, line string, pos int, key rune) (string, int,
 			return line + toAdd, pos + len(toAdd), true
 		}
 		//return line + toAdd + " ", pos + len(toAdd) + 1, true
-
+		return completeArgs(u, line)
 	}
 	return "", pos, false
 }
@@ -249,9 +403,12 @@ func roomAutocomplete(u *user, words []string) string {
 	// trying to refer to a room?
 	if len(words) > 0 && words[len(words)-1][0] == '#' {
 		// don't slice the # off, since the room name includes it
-		for name := range rooms {
-			toAdd := strings.TrimPrefix(name, words[len(words)-1])
-			if toAdd != name { // there was a match, and some text got trimmed!
+		for _, r := range allRooms() {
+			if !r.isListedFor(u) {
+				continue
+			}
+			toAdd := strings.TrimPrefix(r.name, words[len(words)-1])
+			if toAdd != r.name { // there was a match, and some text got trimmed!
 				return toAdd + " "
 			}
 		}
@@ -260,7 +417,8 @@ func roomAutocomplete(u *user, words []string) string {
 }
 
 func newUser(s ssh.Session) *user {
-	term := terminal.NewTerminal(s, "> ")
+	keys := &keyReader{Session: s}
+	term := terminal.NewTerminal(keys, "> ")
 	_ = term.SetSize(10000, 10000) // disable any formatting done by term
 	pty, winChan, _ := s.Pty()
 	w := pty.Window
@@ -287,45 +445,31 @@ func newUser(s ssh.Session) *user {
 		win:           w,
 		lastTimestamp: time.Now(),
 		joinTime:      time.Now(),
+		lastActive:    time.Now(),
 		room:          mainRoom}
+	keys.u = u
 
-	go func() {
-		for u.win = range winChan {
-		}
-	}()
+	go u.watchResize(winChan)
 
 	l.Println("Connected " + u.name + " [" + u.id + "]")
 
-	if bansContains(bans, u.addr, u.id) {
+	if isBanned(u.addr, u.id) {
 		l.Println("Rejected " + u.name + " [" + host + "]")
 		u.writeln(devbot, "**You are banned**. If you feel this was a mistake, please reach out at github.com/quackduck/devzat/issues or email igoel.mail@gmail.com. Please include the following information: [ID "+u.id+"]")
 		u.closeQuietly()
 		return nil
 	}
-	idsInMinToTimes[u.id]++
-	time.AfterFunc(60*time.Second, func() {
-		idsInMinToTimes[u.id]--
-	})
-	if idsInMinToTimes[u.id] > 6 {
-		bans = append(bans, ban{u.addr, u.id})
-		mainRoom.broadcast(devbot, "`"+s.User()+"` has been banned automatically. ID: "+u.id)
+	if !allowLogin(u) {
+		l.Println("Rate limited " + s.User() + " [" + host + "]")
+		u.writeln(devbot, "You're connecting too often. Wait a bit and try again.")
+		u.closeQuietly()
 		return nil
 	}
 
 	clearCMD("", u) // always clear the screen on connect
 	valentines(u)
 
-	if len(backlog) > 0 {
-		lastStamp := backlog[0].timestamp
-		u.rWriteln(printPrettyDuration(u.joinTime.Sub(lastStamp)) + " earlier")
-		for i := range backlog {
-			if backlog[i].timestamp.Sub(lastStamp) > time.Minute {
-				lastStamp = backlog[i].timestamp
-				u.rWriteln(printPrettyDuration(u.joinTime.Sub(lastStamp)) + " earlier")
-			}
-			u.writeln(backlog[i].senderName, backlog[i].text)
-		}
-	}
+	u.printBacklog(mainRoom)
 
 	if err := u.pickUsernameQuietly(s.User()); err != nil { // user exited or had some error
 		l.Println(err)
@@ -352,9 +496,42 @@ func newUser(s ssh.Session) *user {
 		u.writeln("", green.Paint("Welcome to the chat. There are", strconv.Itoa(len(mainRoom.users)-1), "more users"))
 	}
 	mainRoom.broadcast(devbot, u.name+" has joined the chat")
+	notifyFollowers(u, "came online in", mainRoom)
+	recordSession(u, false)
+	u.loadInputHistory()
+	if info := mainRoom.joinInfo(); info != "" {
+		u.writeln(devbot, info)
+	}
+	if n := mentionCount(u); n > 0 {
+		u.writeln(devbot, "You have "+strconv.Itoa(n)+" mentions. Run mentions to see them.")
+	}
+	if cmd := s.Command(); (len(cmd) == 1 && cmd[0] == "tui") || getProfile(u.id).TUI { // ssh -t host tui
+		u.startTUI()
+	}
 	return u
 }
 
+// printBacklog shows the last messages sent in a room
+func (u *user) printBacklog(r *room) {
+	backlog := r.getBacklog()
+	if len(backlog) == 0 {
+		return
+	}
+	now := time.Now()
+	lastStamp := backlog[0].timestamp
+	u.rWriteln(printPrettyDuration(now.Sub(lastStamp)) + " earlier")
+	for i := range backlog {
+		if backlog[i].timestamp.Sub(lastStamp) > time.Minute {
+			lastStamp = backlog[i].timestamp
+			u.rWriteln(printPrettyDuration(now.Sub(lastStamp)) + " earlier")
+		}
+		r.usersMutex.Lock()
+		quote := r.quoteOf(&backlog[i])
+		r.usersMutex.Unlock()
+		u.writeMessage(&backlog[i], quote)
+	}
+}
+
 func valentines(u *user) {
 	if time.Now().Month() == time.February && (time.Now().Day() == 14 || time.Now().Day() == 15 || time.Now().Day() == 13) {
 		// TODO: add a few more random images
@@ -366,10 +543,14 @@ func valentines(u *user) {
 	}
 }
 
-// cleanupRoom deletes a room if it's empty and isn't the main room
+// cleanupRoom deletes a room if it's empty and isn't the main room or a persistent room
 func cleanupRoom(r *room) {
-	if r != mainRoom && len(r.users) == 0 {
-		delete(rooms, r.name)
+	if r != mainRoom && !r.persistent && len(r.users) == 0 {
+		roomsMutex.Lock()
+		if rooms[r.name] == r {
+			delete(rooms, r.name)
+		}
+		roomsMutex.Unlock()
 	}
 }
 
@@ -384,6 +565,9 @@ func (u *user) close(msg string) {
 		u.room.broadcast(devbot, msg)
 		u.room.users = remove(u.room.users, u)
 		cleanupRoom(u.room)
+		notifyFollowers(u, "went offline", nil)
+		recordSession(u, true)
+		u.saveInputHistory()
 	})
 }
 
@@ -392,26 +576,18 @@ func (u *user) closeQuietly() {
 	u.room.usersMutex.Lock()
 	u.room.users = remove(u.room.users, u)
 	u.room.usersMutex.Unlock()
+	if u.fullScreen() != nil {
+		u.session.Write([]byte(leaveAltScreen)) //nolint:errcheck // closing anyway
+	}
 	u.session.Close()
 }
 
 func (u *user) writeln(senderName string, msg string) {
-	if strings.Contains(msg, u.name) { // is a ping
-		msg += "\a"
-	}
-	msg = strings.ReplaceAll(msg, `\n`, "\n")
-	msg = strings.ReplaceAll(msg, `\`+"\n", `\n`) // let people escape newlines
-	if senderName != "" {
-		if strings.HasSuffix(senderName, " <- ") || strings.HasSuffix(senderName, " -> ") { // TODO: kinda hacky DM detection
-			msg = strings.TrimSpace(mdRender(msg, lenString(senderName), u.win.Width))
-			msg = senderName + msg + "\a"
-		} else {
-			msg = strings.TrimSpace(mdRender(msg, lenString(senderName)+2, u.win.Width))
-			msg = senderName + ": " + msg
-		}
-	} else {
-		msg = strings.TrimSpace(mdRender(msg, 0, u.win.Width)) // No sender
-	}
+	u.writelnWithSuffix(senderName, msg, "")
+}
+
+// writelnWithSuffix is like writeln but adds some text after the rendered message
+func (u *user) writelnWithSuffix(senderName string, msg string, suffix string) {
 	if time.Since(u.lastTimestamp) > time.Minute {
 		if u.timezone == nil {
 			u.rWriteln(printPrettyDuration(time.Since(u.joinTime)) + " in")
@@ -424,12 +600,22 @@ func (u *user) writeln(senderName string, msg string) {
 		}
 		u.lastTimestamp = time.Now()
 	}
+	p := printed{senderName: senderName, msg: msg, suffix: suffix}
+	u.remember(p)
+	msg = u.render(p, u.size().Width)
 	if u.pingEverytime && senderName != u.name {
 		msg += "\a"
 	}
 	if !u.bell {
 		msg = strings.ReplaceAll(msg, "\a", "")
 	}
+	if u.fullScreen() != nil { // drawn from the scrollback
+		if strings.Contains(msg, "\a") {
+			u.ring()
+		}
+		u.scheduleDraw()
+		return
+	}
 	_, err := u.term.Write([]byte(msg + "\n"))
 	if err != nil {
 		u.close(u.name + "has left the chat because of an error writing to their terminal: " + err.Error())
@@ -438,15 +624,17 @@ func (u *user) writeln(senderName string, msg string) {
 
 // Write to the right of the user's window
 func (u *user) rWriteln(msg string) {
-	if u.win.Width-lenString(msg) > 0 {
-		u.term.Write([]byte(strings.Repeat(" ", u.win.Width-lenString(msg)) + msg + "\n"))
-	} else {
-		u.term.Write([]byte(msg + "\n"))
+	p := printed{msg: msg, right: true}
+	u.remember(p)
+	if u.fullScreen() != nil {
+		u.scheduleDraw()
+		return
 	}
+	u.term.Write([]byte(u.render(p, u.size().Width) + "\n"))
 }
 
 // pickUsernameQuietly changes the user's username, broadcasting a name change notification if needed.
-// An error is returned if the username entered had a bad word or reading input failed.
+// An error is returned if reading input failed.
 func (u *user) pickUsername(possibleName string) error {
 	oldName := u.name
 	err := u.pickUsernameQuietly(possibleName)
@@ -465,7 +653,7 @@ func (u *user) pickUsernameQuietly(possibleName string) error {
 	var err error
 	for {
 		if possibleName == "" {
-		} else if strings.HasPrefix(possibleName, "#") || possibleName == "devbot" {
+		} else if strings.HasPrefix(possibleName, "#") || possibleName == "devbot" || checkNameFilter(possibleName) != nil {
 			u.writeln("", "Your username is invalid. Pick a different one:")
 		} else if otherUser, dup := userDuplicate(u.room, possibleName); dup {
 			if otherUser == u {
@@ -485,11 +673,6 @@ func (u *user) pickUsernameQuietly(possibleName string) error {
 		possibleName = cleanName(possibleName)
 	}
 
-	if detectBadWords(possibleName) { // sadly this is necessary
-		banUser("devbot [grow up]", u)
-		return errors.New(u.name + "'s username contained a bad word")
-	}
-
 	u.name = possibleName
 
 	if rand.Float64() <= 0.1 { // 10% chance of a random bg color
@@ -521,7 +704,7 @@ func (u *user) changeRoom(r *room) {
 		return
 	}
 	u.room.users = remove(u.room.users, u)
-	u.room.broadcast("", u.name+" is joining "+blue.Paint(r.name)) // tell the old room
+	u.room.broadcast("", u.name+" is joining "+blue.Paint(r.displayName())) // tell the old room
 	cleanupRoom(u.room)
 	u.room = r
 	if _, dup := userDuplicate(u.room, u.name); dup {
@@ -529,6 +712,11 @@ func (u *user) changeRoom(r *room) {
 	}
 	u.room.users = append(u.room.users, u)
 	u.room.broadcast(devbot, u.name+" has joined "+blue.Paint(u.room.name))
+	notifyFollowers(u, "moved to", u.room)
+	u.showRoom(u.room)
+	if info := u.room.joinInfo(); info != "" {
+		u.writeln(devbot, info)
+	}
 }
 
 func (u *user) repl() {
@@ -538,6 +726,7 @@ func (u *user) repl() {
 			u.close(u.name + " has left the chat")
 			return
 		}
+		rows := linesTaken(u.name+": "+line, u.size().Width) // what the terminal echoed, to erase later
 		line += "\n"
 		hasNewlines := false
 		//oldPrompt := u.name + ": "
@@ -547,6 +736,7 @@ func (u *user) repl() {
 			u.term.SetPrompt("")
 			additionalLine := ""
 			additionalLine, err = u.term.ReadLine()
+			rows += linesTaken(additionalLine, u.size().Width)
 			additionalLine = strings.ReplaceAll(additionalLine, `\n`, `\\n`)
 			//additionalLine = strings.ReplaceAll(additionalLine, "\t", strings.Repeat(" ", 8))
 			line += additionalLine + "\n"
@@ -556,40 +746,27 @@ func (u *user) repl() {
 			u.close(u.name + " has left the chat due to an error: " + err.Error())
 			return
 		}
+		u.markActive()
 		if len(line) > maxMsgLen { // limit msg len as early as possible.
 			line = line[0:maxMsgLen]
 		}
 		line = strings.TrimSpace(line)
+		if !hasNewlines && keepInInputHistory(u, line) {
+			u.addToInputHistory(line)
+		} else {
+			u.resetInputState()
+		}
 
 		u.term.SetPrompt(u.name + ": ")
 
-		//fmt.Println("window", u.win)
-		if hasNewlines {
-			calculateLinesTaken(u, u.name+": "+line, u.win.Width)
-		} else {
-			u.term.Write([]byte(strings.Repeat("\033[A\033[2K", int(math.Ceil(float64(lenString(u.name+line)+2)/(float64(u.win.Width))))))) // basically, ceil(length of line divided by term width)
-		}
-		//u.term.Write([]byte(strings.Repeat("\033[A\033[2K", calculateLinesTaken(u.name+": "+line, u.win.Width))))
+		u.eraseLines(rows)
 
 		if line == "" {
 			continue
 		}
 
-		antispamMessages[u.id]++
-		time.AfterFunc(5*time.Second, func() {
-			antispamMessages[u.id]--
-		})
-		if antispamMessages[u.id] >= 30 {
-			u.room.broadcast(devbot, u.name+", stop spamming or you could get banned.")
-		}
-		if antispamMessages[u.id] >= 50 {
-			if !bansContains(bans, u.addr, u.id) {
-				bans = append(bans, ban{u.addr, u.id})
-				saveBans()
-			}
-			u.writeln(devbot, "anti-spam triggered")
-			u.close(red.Paint(u.name + " has been banned for spamming"))
-			return
+		if !allowMessage(u, line) {
+			continue
 		}
 		line = replaceSlackEmoji(line)
 		runCommands(line, u)
@@ -650,30 +827,6 @@ func fetchEmojiSingle(name string) string {
 	return "![" + name + "](https://e.benjaminsmith.dev/" + name + ")"
 }
 
-// may contain a bug ("may" because it could be the terminal's fault)
-func calculateLinesTaken(u *user, s string, width int) {
-	s = stripansi.Strip(s)
-	//fmt.Println("`"+s+"`", "width", width)
-	pos := 0
-	//lines := 1
-	u.term.Write([]byte("\033[A\033[2K"))
-	currLine := ""
-	for _, c := range s {
-		pos++
-		currLine += string(c)
-		if c == '\t' {
-			pos += 8
-		}
-		if c == '\n' || pos > width {
-			pos = 1
-			//lines++
-			u.term.Write([]byte("\033[A\033[2K"))
-		}
-		//fmt.Println(string(c), "`"+currLine+"`", "pos", pos, "lines", lines)
-	}
-	//return lines
-}
-
 // bansContains reports if the addr or id is found in the bans list
 func bansContains(b []ban, addr string, id string) bool {
 	for i := 0; i < len(b); i++ {
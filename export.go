@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/acarl005/stripansi"
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	chromastyles "github.com/alecthomas/chroma/styles"
+	gomarkdown "github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	mdhtml "github.com/gomarkdown/markdown/html"
+	markdown "github.com/quackduck/go-term-markdown"
+)
+
+// exportedMessage is how messages look in JSON exports
+type exportedMessage struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Sender   string    `json:"sender"`
+	SenderID string    `json:"sender_id,omitempty"`
+	Text     string    `json:"text"`
+	ReplyTo  string    `json:"reply_to,omitempty"`
+	Edited   bool      `json:"edited,omitempty"`
+}
+
+type export struct {
+	Room       string            `json:"room"`
+	Topic      string            `json:"topic,omitempty"`
+	From       *time.Time        `json:"from,omitempty"`
+	To         *time.Time        `json:"to,omitempty"`
+	ExportedBy string            `json:"exported_by"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Messages   []exportedMessage `json:"messages"`
+}
+
+// roomMessages returns the messages in a room's history from from up to but not including to (either can be zero).
+// If r isn't nil, only messages sent in r are included, and not ones from earlier rooms with the same name.
+func (h *messageHistory) roomMessages(room string, r *room, from, to time.Time) []exportedMessage {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	msgs := make([]exportedMessage, 0, 100)
+	for _, rec := range h.messages {
+		if rec.Room != room || (r != nil && !rec.isIn(r)) || rec.deleted || (!from.IsZero() && rec.Time.Before(from)) || (!to.IsZero() && !rec.Time.Before(to)) {
+			continue
+		}
+		msgs = append(msgs, exportedMessage{rec.ID, rec.Time, stripansi.Strip(rec.From), rec.FromID, stripansi.Strip(strings.ReplaceAll(rec.Text, `\n`, "\n")), rec.ReplyTo, rec.edited})
+	}
+	return msgs
+}
+
+func (e *export) markdown() []byte {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "# %v\n\n", e.Room)
+	if e.Topic != "" {
+		fmt.Fprintf(b, "_%v_\n\n", e.Topic)
+	}
+	fmt.Fprintf(b, "Exported by %v at %v, %v messages.\n\n", e.ExportedBy, e.ExportedAt.UTC().Format(time.RFC3339), len(e.Messages))
+	for _, m := range e.Messages {
+		fmt.Fprintf(b, "---\n\n**%v** · %v · `%v`", m.Sender, m.Time.UTC().Format("2006-01-02 15:04 UTC"), m.ID)
+		if m.ReplyTo != "" {
+			fmt.Fprintf(b, " · reply to `%v`", m.ReplyTo)
+		}
+		if m.Edited {
+			b.WriteString(" · edited")
+		}
+		fmt.Fprintf(b, "\n\n%v\n\n", m.Text)
+	}
+	return b.Bytes()
+}
+
+var exportTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Room}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; }
+.message { border-top: 1px solid #ddd; padding: 0.5em 0; }
+.meta { color: #777; font-size: 0.9em; }
+pre { padding: 0.7em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Room}}</h1>
+{{if .Topic}}<p><em>{{.Topic}}</em></p>{{end}}
+<p class="meta">Exported by {{.ExportedBy}} at {{.ExportedAt.UTC.Format "2006-01-02 15:04 UTC"}}, {{len .Messages}} messages.</p>
+{{range .Messages}}<div class="message" id="{{.ID}}">
+<div class="meta"><strong>{{.Sender}}</strong> · {{.Time.UTC.Format "2006-01-02 15:04 UTC"}} · {{.ID}}{{if .ReplyTo}} · reply to <a href="#{{.ReplyTo}}">{{.ReplyTo}}</a>{{end}}{{if .Edited}} · edited{{end}}</div>
+{{.HTML}}
+</div>
+{{end}}</body>
+</html>
+`))
+
+// html renders an export as a standalone HTML page, highlighting code blocks with a chroma style
+func (e *export) html(style *chroma.Style) ([]byte, error) {
+	type htmlMessage struct {
+		exportedMessage
+		HTML template.HTML
+	}
+	page := struct {
+		*export
+		Messages []htmlMessage
+	}{export: e}
+	renderer := mdhtml.NewRenderer(mdhtml.RendererOptions{
+		Flags:          mdhtml.CommonFlags | mdhtml.SkipHTML | mdhtml.Safelink | mdhtml.NofollowLinks,
+		RenderNodeHook: highlightCodeBlocks(style),
+	})
+	for _, m := range e.Messages {
+		page.Messages = append(page.Messages, htmlMessage{m, template.HTML(gomarkdown.ToHTML([]byte(m.Text), nil, renderer))}) // raw HTML and unsafe links like javascript: are skipped
+	}
+	b := new(bytes.Buffer)
+	err := exportTemplate.Execute(b, page)
+	return b.Bytes(), err
+}
+
+func highlightCodeBlocks(style *chroma.Style) mdhtml.RenderNodeFunc {
+	formatter := chromahtml.New(chromahtml.WithClasses(false))
+	return func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+		block, ok := node.(*ast.CodeBlock)
+		if !ok {
+			return ast.GoToNext, false
+		}
+		lexer := lexers.Get(strings.TrimSpace(string(block.Info)))
+		if lexer == nil {
+			lexer = lexers.Analyse(string(block.Literal))
+		}
+		if lexer == nil {
+			lexer = lexers.Fallback
+		}
+		iterator, err := chroma.Coalesce(lexer).Tokenise(nil, string(block.Literal))
+		if err == nil {
+			err = formatter.Format(w, style, iterator)
+		}
+		if err != nil {
+			return ast.GoToNext, false // let the renderer print it plainly
+		}
+		return ast.GoToNext, true
+	}
+}
+
+// writeExport saves an export in the data directory and returns the file name.
+// Names have a random part so the download link can't be guessed.
+func writeExport(e *export, format string) (string, error) {
+	var (
+		d   []byte
+		err error
+		ext string
+	)
+	switch {
+	case format == "md" || format == "markdown":
+		d, ext = e.markdown(), "md"
+	case format == "json":
+		d, err = json.MarshalIndent(e, "", "  ")
+		ext = "json"
+	case format == "html" || strings.HasPrefix(format, "html:"):
+		style := markdown.CurrentTheme
+		if name := strings.TrimPrefix(format, "html:"); name != format {
+			if _, ok := chromastyles.Registry[name]; !ok {
+				return "", fmt.Errorf("there's no theme called %v, run theme list to see them", name)
+			}
+			style = chromastyles.Get(name)
+		}
+		d, err = e.html(style)
+		ext = "html"
+	default:
+		return "", fmt.Errorf("unknown format %v, use md, json, html or html:<theme>", format)
+	}
+	if err != nil {
+		return "", err
+	}
+	random := make([]byte, 8)
+	if _, err = rand.Read(random); err != nil {
+		return "", err
+	}
+	name := fileNamePart(strings.TrimPrefix(e.Room, "#")) + "-" + e.ExportedAt.UTC().Format("20060102-1504") + "-" + hex.EncodeToString(random) + "." + ext
+	dir := filepath.Join(getConfig().DataDir, "exports")
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return name, os.WriteFile(filepath.Join(dir, name), d, 0644)
+}
+
+// fileNamePart replaces anything but letters, digits, _ and - so room names can't point outside the exports folder
+func fileNamePart(s string) string {
+	return strings.Map(func(c rune) rune {
+		if c < 128 && (unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-') {
+			return c
+		}
+		return '_'
+	}, s)
+}
+
+// serveExports lets people download exports at /exports/<name>. It has its own mux so the profiling endpoints aren't exposed with it.
+func serveExports(port int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/exports/", serveExport)
+	err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
+	if err != nil {
+		l.Println(err)
+	}
+}
+
+func serveExport(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/exports/")
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(name))
+	http.ServeFile(w, req, filepath.Join(getConfig().DataDir, "exports", name))
+}
+
+func exportCMD(rest string, u *user) {
+	args := strings.Fields(rest)
+	if len(args) == 0 || !strings.HasPrefix(args[0], "#") {
+		u.writeln(devbot, "Usage: export #room [from] [to] [md|json|html|html:<theme>]")
+		return
+	}
+	name := args[0]
+	r, ok := getRoom(name)
+	if !(auth(u) || (ok && r.isOp(u))) { // admins can export rooms that have been deleted
+		u.writeln(devbot, "Not authorized. Only admins and the room's ops can export it.")
+		return
+	}
+	args = args[1:]
+	format := "md"
+	if len(args) > 0 {
+		last := strings.ToLower(args[len(args)-1])
+		if last == "md" || last == "markdown" || last == "json" || last == "html" || strings.HasPrefix(last, "html:") {
+			format = last
+			args = args[:len(args)-1]
+		}
+	}
+	if len(args) > 2 {
+		u.writeln(devbot, "Usage: export #room [from] [to] [md|json|html|html:<theme>]")
+		return
+	}
+	e := &export{Room: name, ExportedBy: stripansi.Strip(u.name), ExportedAt: time.Now()}
+	if ok {
+		e.Topic = r.topic
+	}
+	var (
+		from, to time.Time
+		err      error
+	)
+	loc := u.localTime(time.Now()).Location()
+	if len(args) > 0 {
+		if from, err = parseSearchTime(args[0], loc); err != nil {
+			u.writeln(devbot, err.Error())
+			return
+		}
+		e.From = &from
+	}
+	if len(args) > 1 {
+		if to, err = parseSearchTime(args[1], loc); err != nil {
+			u.writeln(devbot, err.Error())
+			return
+		}
+		if _, dateErr := time.Parse("2006-01-02", args[1]); dateErr == nil { // a date on its own includes that whole day
+			to = to.AddDate(0, 0, 1)
+		}
+		e.To = &to
+	}
+	var only *room
+	if !auth(u) { // ops can't export what was said before their room was made
+		only = r
+	}
+	e.Messages = history.roomMessages(e.Room, only, from, to)
+	if len(e.Messages) == 0 {
+		u.writeln(devbot, "There are no messages to export from "+e.Room+" in that time")
+		return
+	}
+	file, err := writeExport(e, format)
+	if err != nil {
+		u.writeln(devbot, "Couldn't export: "+err.Error())
+		return
+	}
+	l.Println(e.ExportedBy, "exported", len(e.Messages), "messages from", e.Room, "to", file)
+	msg := "Exported " + strconv.Itoa(len(e.Messages)) + " messages to " + filepath.Join(getConfig().DataDir, "exports", file)
+	if getConfig().ExportPort != 0 {
+		msg += "  \nDownload it from port " + strconv.Itoa(getConfig().ExportPort) + " at /exports/" + file
+	}
+	u.writeln(devbot, msg)
+}
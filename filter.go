@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// filter actions, from least to most severe
+const (
+	filterNone = iota
+	filterReplace
+	filterWarn
+	filterBlock
+	filterMute
+	filterBan
+)
+
+var (
+	filterActions = map[string]int{
+		"replace": filterReplace,
+		"warn":    filterWarn,
+		"block":   filterBlock,
+		"mute":    filterMute,
+		"ban":     filterBan,
+	}
+
+	defaultMuteDuration = 5 * time.Minute
+
+	// it's sad that this is necessary, but the internet is harsh
+	defaultFilterRules = []filterRule{
+		{Pattern: `\b(niggers?|faggots?|tranny|trannies)\b`, Action: "ban"},
+		{Pattern: `\b(tranny|trannies|transgenders?|gays?|muslims?|jews?)\b.*\b(kill\w*|death|dead|murder\w*)\b`, Action: "ban"},
+		{Pattern: `\b(kill\w*|death|dead|murder\w*)\b.*\b(tranny|trannies|transgenders?|gays?|muslims?|jews?)\b`, Action: "ban"},
+	}
+)
+
+// filterRule is a single rule in the filter file. Exactly one of Pattern and Word should be set.
+type filterRule struct {
+	Pattern string   `yaml:"pattern,omitempty"`  // a regular expression, matched case-insensitively
+	Word    string   `yaml:"word,omitempty"`     // matched as a whole word, case-insensitively
+	Action  string   `yaml:"action"`             // replace, warn, block, mute or ban
+	Rooms   []string `yaml:"rooms,omitempty"`    // rooms this rule applies in. Empty means everywhere.
+	MuteFor string   `yaml:"mute_for,omitempty"` // how long the mute action lasts, like 10m
+
+	re       *regexp.Regexp
+	action   int
+	muteDur  time.Duration
+	roomsSet map[string]bool
+}
+
+// filterResult is what happened to some text after going through the filter
+type filterResult struct {
+	text    string        // the text with any replacements made
+	action  int           // the most severe action of all rules that matched
+	muteDur time.Duration // the longest mute duration of all matching mute rules
+}
+
+func init() {
+	file := getConfig().FilterFile
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		d, err := yaml.Marshal(defaultFilterRules)
+		if err == nil {
+			err = os.WriteFile(file, d, 0644)
+		}
+		if err != nil {
+			fmt.Println("Error writing the default filter file:", err)
+		}
+		return
+	}
+	rules, err := loadFilterRules(file)
+	if err != nil {
+		fmt.Println("Error loading filter rules, so using the defaults:", err)
+		return
+	}
+	s := *getSettings()
+	s.filterRules = rules
+	currentSettings.Store(&s)
+}
+
+// loadFilterRules reads and compiles the rules in a filter file
+func loadFilterRules(file string) ([]filterRule, error) {
+	d, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []filterRule
+	if err = yaml.Unmarshal(d, &rules); err != nil {
+		return nil, err
+	}
+	return compileFilterRules(rules)
+}
+
+func compileFilterRules(rules []filterRule) ([]filterRule, error) {
+	compiled := make([]filterRule, len(rules))
+	for i, r := range rules {
+		var err error
+		switch {
+		case r.Pattern != "" && r.Word != "":
+			return nil, fmt.Errorf("filter rule %d: has both a pattern and a word", i+1)
+		case r.Pattern != "":
+			r.re, err = regexp.Compile("(?i)" + r.Pattern)
+		case r.Word != "":
+			r.re, err = regexp.Compile(`(?i)\b` + regexp.QuoteMeta(r.Word) + `\b`)
+		default:
+			return nil, fmt.Errorf("filter rule %d: needs a pattern or a word", i+1)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("filter rule %d: %v", i+1, err)
+		}
+		var ok bool
+		if r.action, ok = filterActions[r.Action]; !ok {
+			return nil, fmt.Errorf("filter rule %d: unknown action %q (use replace, warn, block, mute or ban)", i+1, r.Action)
+		}
+		r.muteDur = defaultMuteDuration
+		if r.MuteFor != "" {
+			if r.muteDur, err = time.ParseDuration(r.MuteFor); err != nil {
+				return nil, fmt.Errorf("filter rule %d: %v", i+1, err)
+			}
+		}
+		r.roomsSet = make(map[string]bool, len(r.Rooms))
+		for _, name := range r.Rooms {
+			r.roomsSet[name] = true
+		}
+		compiled[i] = r
+	}
+	return compiled, nil
+}
+
+func mustCompileFilterRules(rules []filterRule) []filterRule {
+	compiled, err := compileFilterRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// filterText runs text sent in a room through the rules. Pass an empty room name to use every rule.
+func filterText(rules []filterRule, roomName string, text string) filterResult {
+	result := filterResult{text: text}
+	for _, r := range rules {
+		if roomName != "" && len(r.roomsSet) > 0 && !r.roomsSet[roomName] {
+			continue
+		}
+		if !r.re.MatchString(result.text) {
+			continue
+		}
+		if r.action > result.action {
+			result.action = r.action
+		}
+		switch r.action {
+		case filterReplace:
+			result.text = r.re.ReplaceAllStringFunc(result.text, func(match string) string {
+				return strings.Repeat("*", len([]rune(match)))
+			})
+		case filterMute:
+			if r.muteDur > result.muteDur {
+				result.muteDur = r.muteDur
+			}
+		}
+	}
+	return result
+}
+
+// applyFilter runs a line from a user through the filter rules and carries out the resulting action.
+// It returns the line to use and whether the line should be used at all.
+func applyFilter(line string, u *user) (string, bool) {
+	result := filterText(getSettings().filterRules, u.room.name, line)
+	switch result.action {
+	case filterBan:
+		banUser("devbot [grow up]", u)
+		return "", false
+	case filterMute:
+		u.mute(result.muteDur)
+		u.writeln(devbot, "Your message was blocked and you've been muted for "+result.muteDur.String())
+		return "", false
+	case filterBlock:
+		u.writeln(devbot, "Your message was blocked")
+		return "", false
+	case filterWarn:
+		u.writeln(devbot, "Careful, "+u.name+". That kind of language could get you muted or banned.")
+	}
+	return result.text, true
+}
+
+// checkNameFilter reports an error if a name matches any filter rule
+func checkNameFilter(name string) error {
+	if filterText(getSettings().filterRules, "", name).action != filterNone {
+		return errors.New("that name isn't allowed")
+	}
+	return nil
+}
+
+func (u *user) mute(d time.Duration) {
+	if until := time.Now().Add(d); until.After(u.mutedUntil) {
+		u.mutedUntil = until
+	}
+}
+
+func (u *user) isMuted() bool {
+	return time.Now().Before(u.mutedUntil)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterText(t *testing.T) {
+	rules := mustCompileFilterRules([]filterRule{
+		{Word: "cunt", Action: "replace"},
+		{Pattern: `sp[a4]m+`, Action: "block"},
+		{Word: "darn", Action: "warn"},
+		{Pattern: `\bfree (crypto|nitro)\b`, Action: "mute", MuteFor: "10m"},
+		{Word: "heck", Action: "mute"},
+		{Word: "rust", Action: "replace", Rooms: []string{"#go"}},
+		{Word: "nazi", Action: "ban"},
+	})
+	tests := []struct {
+		name    string
+		room    string
+		text    string
+		want    string
+		action  int
+		muteDur time.Duration
+	}{
+		{"clean", "#main", "hello there", "hello there", filterNone, 0},
+		{"whole word is masked", "#main", "what a cunt", "what a ****", filterReplace, 0},
+		{"whole word ignores case", "#main", "CUNT!", "****!", filterReplace, 0},
+		{"word inside another word is fine", "#main", "I live in Scunthorpe", "I live in Scunthorpe", filterNone, 0},
+		{"regex matches", "#main", "buy sp4mmm now", "buy sp4mmm now", filterBlock, 0},
+		{"regex matches inside words", "#main", "antispam", "antispam", filterBlock, 0},
+		{"warn", "#main", "oh darn", "oh darn", filterWarn, 0},
+		{"mute uses the rule's duration", "#main", "get FREE NITRO here", "get FREE NITRO here", filterMute, 10 * time.Minute},
+		{"mute uses the default duration", "#main", "what the heck", "what the heck", filterMute, defaultMuteDuration},
+		{"longest mute wins", "#main", "heck, free crypto", "heck, free crypto", filterMute, 10 * time.Minute},
+		{"most severe action wins", "#main", "darn spam", "darn spam", filterBlock, 0},
+		{"mask still applies with other actions", "#main", "darn cunt", "darn ****", filterWarn, 0},
+		{"ban", "#main", "nazi", "nazi", filterBan, 0},
+		{"room rule applies in its room", "#go", "rust is nice", "**** is nice", filterReplace, 0},
+		{"room rule doesn't apply elsewhere", "#main", "rust is nice", "rust is nice", filterNone, 0},
+		{"no room uses every rule", "", "rust", "****", filterReplace, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := filterText(rules, tt.room, tt.text)
+			if got.text != tt.want || got.action != tt.action || got.muteDur != tt.muteDur {
+				t.Errorf("filterText(%q, %q) = {%q, %d, %v}, want {%q, %d, %v}",
+					tt.room, tt.text, got.text, got.action, got.muteDur, tt.want, tt.action, tt.muteDur)
+			}
+		})
+	}
+}
+
+func TestCompileFilterRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    filterRule
+		wantErr string // empty if the rule is valid
+	}{
+		{"word", filterRule{Word: "a.b", Action: "replace"}, ""},
+		{"pattern", filterRule{Pattern: `a+b`, Action: "warn"}, ""},
+		{"mute with duration", filterRule{Word: "x", Action: "mute", MuteFor: "1h"}, ""},
+		{"invalid pattern", filterRule{Pattern: `(unclosed`, Action: "block"}, "missing closing )"},
+		{"invalid repetition", filterRule{Pattern: `*x`, Action: "block"}, "missing argument to repetition operator"},
+		{"both pattern and word", filterRule{Pattern: "a", Word: "a", Action: "block"}, "both a pattern and a word"},
+		{"neither pattern nor word", filterRule{Action: "block"}, "needs a pattern or a word"},
+		{"unknown action", filterRule{Word: "a", Action: "explode"}, "unknown action"},
+		{"missing action", filterRule{Word: "a"}, "unknown action"},
+		{"invalid duration", filterRule{Word: "a", Action: "mute", MuteFor: "forever"}, "invalid duration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileFilterRules([]filterRule{tt.rule})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("expected an error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("error %q doesn't contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWordRuleIsLiteral(t *testing.T) {
+	rules := mustCompileFilterRules([]filterRule{{Word: "a.b", Action: "block"}})
+	if got := filterText(rules, "", "axb").action; got != filterNone {
+		t.Errorf("word rule matched as a regex, got action %d", got)
+	}
+	if got := filterText(rules, "", "say a.b now").action; got != filterBlock {
+		t.Errorf("word rule didn't match literally, got action %d", got)
+	}
+}
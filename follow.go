@@ -0,0 +1,128 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/acarl005/stripansi"
+)
+
+var (
+	follows      = make(map[string]map[string]string) // user ID to the IDs of users they follow, to their names
+	followsMutex sync.Mutex
+)
+
+func init() {
+	if err := loadJSON("follows.json", &follows); err != nil {
+		l.Println("Error reading follows:", err)
+	}
+}
+
+// saveFollows saves who follows who. followsMutex must be held.
+func saveFollows() {
+	if err := saveJSON("follows.json", follows); err != nil {
+		l.Println("Error saving follows:", err)
+	}
+}
+
+// notifyFollowers tells everyone online who follows u that they did something, like "joined #rust".
+// Room names are hidden from followers who can't see the room.
+func notifyFollowers(u *user, did string, r *room) {
+	followsMutex.Lock()
+	followers := make(map[string]bool)
+	for id, following := range follows {
+		if _, ok := following[u.id]; ok {
+			followers[id] = true
+			following[u.id] = stripansi.Strip(u.name) // keep names current for the following list
+		}
+	}
+	followsMutex.Unlock()
+	if len(followers) == 0 {
+		return
+	}
+	for _, us := range onlineUsers() {
+		if !followers[us.id] || us == u || us.isIgnoring(u.id) {
+			continue
+		}
+		msg := stripansi.Strip(u.name) + " " + did
+		if r != nil {
+			msg += " " + roomNameFor(us, r)
+		}
+		us.writeln(devbot, gray.Paint(msg))
+	}
+}
+
+func followCMD(rest string, u *user) {
+	name := strings.TrimPrefix(rest, "@")
+	if name == "" {
+		u.writeln(devbot, "Usage: follow <user>")
+		return
+	}
+	peer, ok := findUserByNameAnywhere(name)
+	if !ok {
+		u.writeln(devbot, "User not found")
+		return
+	}
+	if peer == u {
+		u.writeln(devbot, "You always know where you are")
+		return
+	}
+	followsMutex.Lock()
+	if follows[u.id] == nil {
+		follows[u.id] = make(map[string]string)
+	}
+	follows[u.id][peer.id] = stripansi.Strip(peer.name)
+	saveFollows()
+	followsMutex.Unlock()
+	u.writeln(devbot, "Following "+stripansi.Strip(peer.name)+". You'll be told when they come online, go offline or change rooms.")
+}
+
+func unfollowCMD(rest string, u *user) {
+	name := strings.TrimPrefix(rest, "@")
+	if name == "" {
+		u.writeln(devbot, "Usage: unfollow <user>")
+		return
+	}
+	followsMutex.Lock()
+	defer followsMutex.Unlock()
+	for id, followedName := range follows[u.id] {
+		if followedName == name || id == name {
+			delete(follows[u.id], id)
+			if len(follows[u.id]) == 0 {
+				delete(follows, u.id)
+			}
+			saveFollows()
+			u.writeln(devbot, "Unfollowed "+followedName)
+			return
+		}
+	}
+	u.writeln(devbot, "You aren't following "+name)
+}
+
+func followingCMD(_ string, u *user) {
+	followsMutex.Lock()
+	ids := make(map[string]string, len(follows[u.id]))
+	for id, name := range follows[u.id] {
+		ids[id] = name
+	}
+	followsMutex.Unlock()
+	if len(ids) == 0 {
+		u.writeln(devbot, "You aren't following anyone. Use follow <user> to start.")
+		return
+	}
+	online := make(map[string]*user)
+	for _, us := range onlineUsers() {
+		online[us.id] = us
+	}
+	lines := make([]string, 0, len(ids))
+	for id, name := range ids {
+		if us, ok := online[id]; ok {
+			lines = append(lines, green.Paint("●")+" "+stripansi.Strip(us.name)+" in "+roomNameFor(u, us.room))
+		} else {
+			lines = append(lines, gray.Paint("○ "+name+" (offline)"))
+		}
+	}
+	sort.Strings(lines)
+	u.writeln(devbot, "You're following:  \n"+strings.Join(lines, "  \n"))
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/acarl005/stripansi"
+)
+
+// historyRecord is a line in the history file. Messages are added with an empty Op, and later lines
+// with Op set to "edit", "delete" or "react" change earlier messages. Reactions toggle, like the command.
+type historyRecord struct {
+	Op      string    `json:"op,omitempty"`
+	ID      string    `json:"id,omitempty"`       // the message's ID in its room
+	Room    string    `json:"room,omitempty"`     // empty for DMs
+	RoomKey string    `json:"room_key,omitempty"` // the room's key, since a name can be used again after a room is deleted
+	Private bool      `json:"private,omitempty"`  // if the room was hidden, which is only used once the room is gone
+	Time    time.Time `json:"time"`
+	From    string    `json:"from,omitempty"`
+	FromID  string    `json:"from_id,omitempty"`
+	To      string    `json:"to,omitempty"` // who a DM was sent to
+	ToID    string    `json:"to_id,omitempty"`
+	Text    string    `json:"text,omitempty"`
+	ReplyTo string    `json:"reply_to,omitempty"`
+
+	edited    bool
+	deleted   bool
+	reactions []reaction
+}
+
+// messageHistory is every message ever sent, with an index from words to messages
+type messageHistory struct {
+	mutex    sync.Mutex
+	file     *os.File
+	messages []*historyRecord
+	index    map[string][]int // word to positions in messages, in increasing order
+}
+
+const maxSearchResults = 20
+
+var history = &messageHistory{index: make(map[string][]int)}
+
+// loadHistory reads the history file, builds the search index and fills room backlogs
+func loadHistory() {
+	file := filepath.Join(getConfig().DataDir, "history.jsonl")
+	if f, err := os.Open(file); err == nil {
+		reader := bufio.NewReader(f) // not a Scanner, since escaped JSON can make a line several times longer than its message
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) != 0 {
+				rec := new(historyRecord)
+				if jsonErr := json.Unmarshal(line, rec); jsonErr != nil {
+					l.Println("Skipping bad history line:", jsonErr)
+				} else {
+					history.apply(rec)
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					l.Println("Error reading history:", err)
+				}
+				break
+			}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		l.Println("Error reading history:", err)
+	}
+
+	if err := os.MkdirAll(getConfig().DataDir, 0755); err != nil {
+		l.Println("Error opening history file:", err)
+		return
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		l.Println("Error opening history file:", err)
+		return
+	}
+	history.file = f
+
+	for _, rec := range history.messages { // messages are in order, so this leaves the most recent ones
+		r, ok := getRoom(rec.Room)
+		if !ok || rec.deleted || !rec.isIn(r) {
+			continue
+		}
+		text := rec.Text
+		if rec.ReplyTo != "" && strings.HasPrefix(text, "> ") && strings.Contains(text, "\n\n") { // replies used to have the quote in their text
+			text = text[strings.Index(text, "\n\n")+2:]
+		}
+		r.backlog = append(r.backlog, backlogMessage{id: rec.ID, timestamp: rec.Time, senderName: rec.From, senderID: rec.FromID, text: text + "\n", replyTo: rec.ReplyTo, edited: rec.edited, reactions: rec.reactions})
+		if len(r.backlog) > roomHistory {
+			r.backlog = r.backlog[1:]
+		}
+	}
+	l.Println("Loaded", len(history.messages), "messages of history")
+}
+
+// apply adds a record to the in-memory history. h.mutex must be held (or not needed yet).
+func (h *messageHistory) apply(rec *historyRecord) {
+	switch rec.Op {
+	case "":
+		h.messages = append(h.messages, rec)
+		h.addToIndex(len(h.messages)-1, rec.Text)
+	case "edit", "delete", "react":
+		for i := len(h.messages) - 1; i >= 0; i-- {
+			m := h.messages[i]
+			if m.ID == rec.ID && m.Room == rec.Room && m.RoomKey == rec.RoomKey {
+				switch rec.Op {
+				case "delete":
+					m.deleted = true
+				case "react":
+					m.reactions, _ = toggleReaction(m.reactions, rec.Text, rec.FromID)
+				default:
+					m.Text = rec.Text
+					m.edited = true
+					h.addToIndex(i, rec.Text) // old words stay in the index, so search checks matches again
+				}
+				return
+			}
+		}
+	}
+}
+
+// isIn reports if a message was sent in r, and not in an earlier room with the same name.
+// Messages from before rooms had keys are only matched with rooms that can't have been deleted since.
+func (rec *historyRecord) isIn(r *room) bool {
+	if rec.Room != r.name {
+		return false
+	}
+	if rec.RoomKey == "" {
+		return r.persistent || r == mainRoom
+	}
+	return rec.RoomKey == r.key
+}
+
+func (h *messageHistory) addToIndex(pos int, text string) {
+	for word := range wordSet(text) {
+		list := h.index[word]
+		if len(list) == 0 || list[len(list)-1] != pos {
+			h.index[word] = append(list, pos)
+		}
+	}
+}
+
+// record applies a record and appends it to the history file
+func (h *messageHistory) record(rec *historyRecord) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.apply(rec)
+	if h.file == nil {
+		return
+	}
+	d, err := json.Marshal(rec)
+	if err == nil {
+		_, err = h.file.Write(append(d, '\n'))
+	}
+	if err != nil {
+		l.Println("Error saving history:", err)
+	}
+}
+
+// recordRoomMessage saves a message sent in a room
+func recordRoomMessage(r *room, m *backlogMessage) {
+	history.record(&historyRecord{ID: m.id, Room: r.name, RoomKey: r.key, Private: r.isHidden(), Time: m.timestamp, From: m.senderName, FromID: m.senderID, Text: m.text, ReplyTo: m.replyTo})
+}
+
+// recordDM saves a direct message
+func recordDM(from *user, to *user, msg string) {
+	history.record(&historyRecord{Time: time.Now(), From: from.name, FromID: from.id, To: to.name, ToID: to.id, Text: msg})
+}
+
+func recordEdit(r *room, id string, text string) {
+	history.record(&historyRecord{Op: "edit", ID: id, Room: r.name, RoomKey: r.key, Time: time.Now(), Text: text})
+}
+
+// recordReaction saves a reaction being added or taken back
+func recordReaction(r *room, id string, u *user, emoji string) {
+	history.record(&historyRecord{Op: "react", ID: id, Room: r.name, RoomKey: r.key, Time: time.Now(), FromID: u.id, Text: emoji})
+}
+
+func recordDelete(r *room, id string) {
+	history.record(&historyRecord{Op: "delete", ID: id, Room: r.name, RoomKey: r.key, Time: time.Now()})
+}
+
+// words splits text into lowercase words, ignoring formatting
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(stripansi.Strip(strings.ReplaceAll(text, `\n`, " "))), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func wordSet(text string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range words(text) {
+		set[w] = true
+	}
+	return set
+}
+
+type searchQuery struct {
+	words   []string
+	phrases [][]string // words that have to be next to each other, in order
+	room    string
+	from    string
+	before  time.Time
+	after   time.Time
+	dms     bool   // only DMs
+	dmWith  string // only DMs with this person
+}
+
+// parseSearchQuery parses a query like `#room deploy "on friday" from:bob after:2022-03-01`. Dates are in loc.
+func parseSearchQuery(query string, loc *time.Location) (searchQuery, error) {
+	q := searchQuery{}
+	fields := make([]string, 0)
+	for i, part := range strings.Split(query, `"`) {
+		if i%2 == 0 { // outside quotes
+			fields = append(fields, strings.Fields(part)...)
+			continue
+		}
+		if phrase := words(part); len(phrase) > 0 {
+			q.phrases = append(q.phrases, phrase)
+		}
+	}
+	for _, field := range fields {
+		lower := strings.ToLower(field)
+		var err error
+		switch {
+		case strings.HasPrefix(field, "#") && q.room == "" && len(q.words) == 0:
+			q.room = field
+		case strings.HasPrefix(lower, "from:"):
+			q.from = strings.TrimPrefix(strings.TrimPrefix(field, field[:5]), "@")
+		case strings.HasPrefix(lower, "before:"):
+			q.before, err = parseSearchTime(field[7:], loc)
+		case strings.HasPrefix(lower, "after:"):
+			q.after, err = parseSearchTime(field[6:], loc)
+		case lower == "in:dms" || lower == "dm:" || lower == "dm:*":
+			q.dms = true
+		case strings.HasPrefix(lower, "dm:"):
+			q.dms = true
+			q.dmWith = strings.TrimPrefix(field[3:], "@")
+		default:
+			q.words = append(q.words, words(field)...)
+		}
+		if err != nil {
+			return q, err
+		}
+	}
+	for _, phrase := range q.phrases {
+		q.words = append(q.words, phrase...) // so the index can narrow down the messages
+	}
+	return q, nil
+}
+
+// parseSearchTime parses dates like 2022-03-01 or 2022-03-01T15:04 in loc, or how long ago like 3d or 2h
+func parseSearchTime(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	d, err := parseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("I couldn't understand the time %q. Use a date like 2022-03-01 or how long ago like 3d or 2h.", s)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// matches checks everything about a message except whether the user can see it
+func (q searchQuery) matches(rec *historyRecord) bool {
+	if rec.deleted {
+		return false
+	}
+	if q.dms != (rec.Room == "") {
+		return false
+	}
+	if q.room != "" && rec.Room != q.room {
+		return false
+	}
+	if q.from != "" && !strings.EqualFold(stripansi.Strip(rec.From), q.from) {
+		return false
+	}
+	if q.dmWith != "" && !strings.EqualFold(stripansi.Strip(rec.From), q.dmWith) && !strings.EqualFold(stripansi.Strip(rec.To), q.dmWith) {
+		return false
+	}
+	if !q.before.IsZero() && !rec.Time.Before(q.before) {
+		return false
+	}
+	if !q.after.IsZero() && !rec.Time.After(q.after) {
+		return false
+	}
+	if len(q.words) > 0 { // the index can have old words from before an edit
+		set := wordSet(rec.Text)
+		for _, w := range q.words {
+			if !set[w] {
+				return false
+			}
+		}
+	}
+	if len(q.phrases) > 0 {
+		text := words(rec.Text)
+		for _, phrase := range q.phrases {
+			if !containsPhrase(text, phrase) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// containsPhrase reports if the words of phrase are in text next to each other, in order
+func containsPhrase(text []string, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(text); i++ {
+		found := true
+		for j := range phrase {
+			if text[i+j] != phrase[j] {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// canSee reports if a user is allowed to see a message in search results.
+// Messages in rooms are checked against the room as it is now. Once a room is gone, messages
+// sent while it was hidden can only be seen by their sender and admins.
+func canSee(u *user, rec *historyRecord) bool {
+	if u.isIgnoring(rec.FromID) {
+		return false
+	}
+	if rec.Room == "" {
+		return rec.FromID == u.id || rec.ToID == u.id
+	}
+	if auth(u) {
+		return true
+	}
+	if r, ok := getRoom(rec.Room); ok && rec.isIn(r) {
+		return r.isListedFor(u)
+	}
+	return !rec.Private || rec.FromID == u.id
+}
+
+// search returns the newest messages matching a query that u can see
+func (h *messageHistory) search(q searchQuery, u *user) []historyRecord {
+	h.mutex.Lock()
+	var positions []int
+	if len(q.words) > 0 {
+		positions = h.candidates(q.words)
+	} else {
+		positions = make([]int, len(h.messages))
+		for i := range positions {
+			positions[i] = i
+		}
+	}
+	matched := make([]historyRecord, 0, maxSearchResults)
+	for i := len(positions) - 1; i >= 0; i-- {
+		rec := h.messages[positions[i]]
+		if !q.matches(rec) {
+			continue
+		}
+		matched = append(matched, *rec)
+		if len(matched) == 10*maxSearchResults { // leave room for messages u can't see
+			break
+		}
+	}
+	h.mutex.Unlock()
+
+	// check access without holding h.mutex, since that locks rooms
+	results := make([]historyRecord, 0, maxSearchResults)
+	for _, rec := range matched {
+		if canSee(u, &rec) {
+			results = append(results, rec)
+			if len(results) == maxSearchResults {
+				break
+			}
+		}
+	}
+	return results
+}
+
+// candidates intersects the index lists of all words. h.mutex must be held.
+func (h *messageHistory) candidates(words []string) []int {
+	lists := make([][]int, 0, len(words))
+	for _, w := range words {
+		list, ok := h.index[w]
+		if !ok {
+			return nil
+		}
+		lists = append(lists, list)
+	}
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+	result := lists[0]
+	for _, list := range lists[1:] {
+		result = intersect(result, list)
+	}
+	return result
+}
+
+// intersect returns the numbers in both sorted lists
+func intersect(a, b []int) []int {
+	result := make([]int, 0, len(a))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+func searchCMD(rest string, u *user) {
+	if rest == "" {
+		u.writeln(devbot, `Usage: search [#room] <word|"some words"> [from:user] [before:date] [after:date] [dm:user|in:dms]`)
+		return
+	}
+	q, err := parseSearchQuery(rest, u.localTime(time.Now()).Location())
+	if err != nil {
+		u.writeln(devbot, err.Error())
+		return
+	}
+	results := history.search(q, u)
+	if len(results) == 0 {
+		u.writeln(devbot, "No messages found")
+		return
+	}
+	msg := "Found " + strconv.Itoa(len(results)) + " messages (newest first):  \n"
+	for _, rec := range results {
+		where := rec.Room
+		if where == "" {
+			where = "DM " + stripansi.Strip(rec.From) + " → " + stripansi.Strip(rec.To)
+		}
+		msg += gray.Paint(u.formatTime(rec.Time)+" "+where) + " " + rec.From + ": " + snippet(rec.Text, 100)
+		if rec.ID != "" {
+			msg += " " + gray.Paint("["+rec.ID+"]")
+		}
+		msg += "  \n"
+	}
+	u.writeln(devbot, msg)
+}
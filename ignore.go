@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/acarl005/stripansi"
+)
+
+var (
+	ignores      = make(map[string]map[string]string) // user ID to the IDs of users they ignore, to their names
+	ignoresMutex sync.Mutex
+)
+
+func init() {
+	if err := loadJSON("ignores.json", &ignores); err != nil {
+		l.Println("Error reading ignore lists:", err)
+	}
+}
+
+// saveIgnores saves everyone's ignore lists. ignoresMutex must be held.
+func saveIgnores() {
+	if err := saveJSON("ignores.json", ignores); err != nil {
+		l.Println("Error saving ignore lists:", err)
+	}
+}
+
+// isIgnoring reports if u doesn't want to see anything from the user with the ID
+func (u *user) isIgnoring(id string) bool {
+	return isIgnoring(u.id, id)
+}
+
+// isIgnoring is like (*user).isIgnoring but works for users who aren't online
+func isIgnoring(userID, id string) bool {
+	if id == "" {
+		return false
+	}
+	ignoresMutex.Lock()
+	defer ignoresMutex.Unlock()
+	_, ok := ignores[userID][id]
+	return ok
+}
+
+func ignoreCMD(rest string, u *user) {
+	name := strings.TrimPrefix(rest, "@")
+	if name == "" {
+		u.writeln(devbot, "Usage: ignore <user>")
+		return
+	}
+	victim, ok := findUserByNameAnywhere(name)
+	if !ok {
+		u.writeln(devbot, "User not found")
+		return
+	}
+	if victim == u {
+		u.writeln(devbot, "You can't ignore yourself, much as you might want to")
+		return
+	}
+	ignoresMutex.Lock()
+	if ignores[u.id] == nil {
+		ignores[u.id] = make(map[string]string)
+	}
+	ignores[u.id][victim.id] = stripansi.Strip(victim.name)
+	saveIgnores()
+	ignoresMutex.Unlock()
+	u.writeln(devbot, "Ignoring "+stripansi.Strip(victim.name)+". You won't see their messages, DMs or mentions. Undo with unignore "+stripansi.Strip(victim.name))
+}
+
+func unignoreCMD(rest string, u *user) {
+	name := strings.TrimPrefix(rest, "@")
+	if name == "" {
+		u.writeln(devbot, "Usage: unignore <user>")
+		return
+	}
+	ignoresMutex.Lock()
+	defer ignoresMutex.Unlock()
+	for id, ignoredName := range ignores[u.id] {
+		if ignoredName == name || id == name {
+			delete(ignores[u.id], id)
+			if len(ignores[u.id]) == 0 {
+				delete(ignores, u.id)
+			}
+			saveIgnores()
+			u.writeln(devbot, "Stopped ignoring "+ignoredName)
+			return
+		}
+	}
+	if victim, ok := findUserByNameAnywhere(name); ok { // they might have changed their name
+		if ignoredName, ok := ignores[u.id][victim.id]; ok {
+			delete(ignores[u.id], victim.id)
+			saveIgnores()
+			u.writeln(devbot, "Stopped ignoring "+ignoredName)
+			return
+		}
+	}
+	u.writeln(devbot, "You aren't ignoring "+name)
+}
+
+func ignoredCMD(_ string, u *user) {
+	ignoresMutex.Lock()
+	defer ignoresMutex.Unlock()
+	if len(ignores[u.id]) == 0 {
+		u.writeln(devbot, "You aren't ignoring anyone")
+		return
+	}
+	names := make([]string, 0, len(ignores[u.id]))
+	for id, name := range ignores[u.id] {
+		names = append(names, name+" "+gray.Paint("("+shortID(id)+")"))
+	}
+	sort.Strings(names)
+	u.writeln(devbot, "You're ignoring: "+strings.Join(names, ", "))
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	chromastyles "github.com/alecthomas/chroma/styles"
+)
+
+const maxInputHistory = 100
+
+var (
+	zoneIDs     []string
+	zoneIDsOnce sync.Once
+)
+
+// loadInputHistory gets the lines u typed in earlier sessions
+func (u *user) loadInputHistory() {
+	u.history = getProfile(u.id).History
+	u.resetInputState()
+}
+
+// saveInputHistory keeps u's input history in their profile for next time
+func (u *user) saveInputHistory() {
+	history := u.history
+	updateProfile(u.id, func(p *profile) { p.History = history })
+}
+
+// keepInInputHistory reports if a line should be saved in u's input history.
+// DMs, reports and anything that might have a password in it aren't, since the history is kept on disk.
+func keepInInputHistory(u *user, line string) bool {
+	lower := strings.ToLower(line)
+	return u.messaging == nil && !strings.HasPrefix(line, "=") && !strings.HasPrefix(lower, "report ") &&
+		!strings.Contains(lower, "password") && !strings.Contains(lower, "passwd")
+}
+
+// addToInputHistory remembers a line u sent, skipping repeats
+func (u *user) addToInputHistory(line string) {
+	u.resetInputState()
+	if line == "" || (len(u.history) > 0 && u.history[len(u.history)-1] == line) {
+		return
+	}
+	u.history = append(u.history, line)
+	if len(u.history) > maxInputHistory {
+		u.history = append([]string(nil), u.history[len(u.history)-maxInputHistory:]...)
+	}
+}
+
+// resetInputState stops browsing or searching the history, which happens whenever a line is sent
+func (u *user) resetInputState() {
+	u.historyIndex = -1
+	u.historyDraft = ""
+	u.historySearch = ""
+	u.historySearchFrom = -1
+	u.historySearchResult = ""
+}
+
+// browseHistory handles the up and down keys. The line being typed is kept so going back down past the newest entry restores it.
+func (u *user) browseHistory(line string, up bool) (string, int, bool) {
+	if u.historyIndex == -1 {
+		if !up || len(u.history) == 0 {
+			return line, len(line), true
+		}
+		u.historyDraft = line
+		u.historyIndex = len(u.history)
+	}
+	if up {
+		if u.historyIndex > 0 {
+			u.historyIndex--
+		}
+		return u.history[u.historyIndex], len(u.history[u.historyIndex]), true
+	}
+	u.historyIndex++
+	if u.historyIndex >= len(u.history) {
+		draft := u.historyDraft
+		u.historyIndex = -1
+		u.historyDraft = ""
+		return draft, len(draft), true
+	}
+	return u.history[u.historyIndex], len(u.history[u.historyIndex]), true
+}
+
+// searchHistory handles Ctrl-R. The line is the search, and pressing Ctrl-R again finds older matches.
+func (u *user) searchHistory(line string) (string, int, bool) {
+	if u.historySearchFrom == -1 || line != u.historySearchResult { // a new search
+		u.historySearch = line
+		u.historySearchFrom = len(u.history)
+	}
+	for i := u.historySearchFrom - 1; i >= 0; i-- {
+		if strings.Contains(u.history[i], u.historySearch) && u.history[i] != line {
+			u.historySearchFrom = i
+			u.historySearchResult = u.history[i]
+			u.historyIndex = -1
+			return u.history[i], len(u.history[i]), true
+		}
+	}
+	u.ring()
+	return line, len(line), true
+}
+
+// completeArgs completes command names and the first argument of some commands
+func completeArgs(u *user, line string) (string, int, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", 0, false
+	}
+	var candidates []string
+	typed := ""
+	if len(fields) == 1 && !strings.HasSuffix(line, " ") {
+		candidates = commandNames()
+		typed = fields[0]
+	} else {
+		candidates = argCandidates(fields[0])
+		typed = strings.TrimLeft(strings.TrimPrefix(strings.TrimLeft(line, " "), fields[0]), " ")
+	}
+	if candidates == nil {
+		return "", 0, false
+	}
+	matches := make([]string, 0, 10)
+	for _, c := range candidates {
+		if strings.HasPrefix(strings.ToLower(c), strings.ToLower(typed)) {
+			matches = append(matches, c)
+		}
+	}
+	start := line[:len(line)-len(typed)]
+	switch len(matches) {
+	case 0:
+		u.ring()
+		return line, len(line), true
+	case 1:
+		line = start + matches[0] + " "
+		return line, len(line), true
+	}
+	if common := commonPrefix(matches); len(common) > len(typed) {
+		line = start + common
+		return line, len(line), true
+	}
+	shown := matches
+	if len(shown) > 50 {
+		shown = shown[:50]
+	}
+	msg := strings.Join(shown, ", ")
+	if len(matches) > len(shown) {
+		msg += " and " + strconv.Itoa(len(matches)-len(shown)) + " more"
+	}
+	u.writeln(devbot, msg)
+	return line, len(line), true
+}
+
+// commandNames lists the commands that are shown in help
+func commandNames() []string {
+	names := make([]string, 0, len(cmds)+len(cmdsRest))
+	for _, c := range append(append([]cmd{}, cmds...), cmdsRest...) {
+		if !strings.ContainsAny(c.name, "=<") {
+			names = append(names, c.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// argCandidates lists what the first argument of a command could be, or nil if there's nothing to complete
+func argCandidates(command string) []string {
+	switch command {
+	case "theme":
+		return append(chromastyles.Names(), "list")
+	case "color", "colour":
+		names := []string{"random", "bg-random", "bg-off", "which"}
+		for _, s := range styles {
+			names = append(names, s.name)
+		}
+		return names
+	case "tz":
+		return timezoneIDs()
+	}
+	return nil
+}
+
+// timezoneIDs lists the IANA timezones on this system, plus the abbreviations tz understands
+func timezoneIDs() []string {
+	zoneIDsOnce.Do(func() {
+		zoneIDs = []string{"PST", "PDT", "CST", "CDT", "EST", "EDT", "MT"}
+		found := false
+		for _, dir := range []string{"/usr/share/zoneinfo", "/usr/share/lib/zoneinfo", "/usr/lib/locale/TZ"} {
+			_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+				name := strings.TrimPrefix(path, dir+"/")
+				if info.IsDir() {
+					if name == "posix" || name == "right" {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if name[0] >= 'A' && name[0] <= 'Z' && !strings.Contains(name, ".") { // skips files like zone.tab and posixrules
+					zoneIDs = append(zoneIDs, name)
+					found = true
+				}
+				return nil
+			})
+			if found {
+				break
+			}
+		}
+		if !found {
+			zoneIDs = append(zoneIDs, "UTC", "Europe/London", "Europe/Berlin", "Asia/Dubai", "Asia/Kolkata", "Asia/Tokyo",
+				"Australia/Sydney", "America/New_York", "America/Chicago", "America/Denver", "America/Los_Angeles")
+		}
+		sort.Strings(zoneIDs)
+	})
+	return zoneIDs
+}
+
+// commonPrefix finds the longest prefix all the strings share, ignoring case
+func commonPrefix(strs []string) string {
+	prefix := strs[0]
+	for _, s := range strs[1:] {
+		i := 0
+		for i < len(prefix) && i < len(s) && strings.EqualFold(prefix[i:i+1], s[i:i+1]) {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	return prefix
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acarl005/stripansi"
+)
+
+const (
+	maxInboxMentions    = 100
+	mentionSaveInterval = 10 * time.Second // edits and @everyone can change many inboxes at once, so saves are batched
+)
+
+type mention struct {
+	Time   time.Time
+	Room   string
+	From   string
+	FromID string
+	MsgID  string
+	Text   string
+}
+
+var (
+	mentions      = make(map[string][]mention) // user ID to mentions of them, oldest first
+	mentionsMutex sync.Mutex
+	mentionsDirty bool // set if mentions changed since they were last saved
+)
+
+func init() {
+	if err := loadJSON("mentions.json", &mentions); err != nil {
+		l.Println("Error reading mentions:", err)
+	}
+}
+
+// saveMentions saves the mentions inbox. mentionsMutex must be held.
+func saveMentions() {
+	if err := saveJSON("mentions.json", mentions); err != nil {
+		l.Println("Error saving mentions:", err)
+	}
+	mentionsDirty = false
+}
+
+// runMentionSaves saves mentions when they've changed, at most once every mentionSaveInterval
+func runMentionSaves() {
+	for range time.Tick(mentionSaveInterval) {
+		mentionsMutex.Lock()
+		if mentionsDirty {
+			saveMentions()
+		}
+		mentionsMutex.Unlock()
+	}
+}
+
+// onlineUsers returns everyone connected, in every room
+func onlineUsers() []*user {
+	all := make([]*user, 0, 10)
+	for _, r := range allRooms() {
+		r.usersMutex.Lock()
+		all = append(all, r.users...)
+		r.usersMutex.Unlock()
+	}
+	return all
+}
+
+// isNameChar reports if c can continue a word, so "@bob" isn't found in "@bobby"
+func isNameChar(c byte) bool {
+	return c == '_' || c == '-' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// mentionable is someone who can be mentioned: a user who's online, or someone who's been online before
+type mentionable struct {
+	id   string
+	name string // without colors
+	u    *user  // nil if they're offline
+}
+
+// mentionablePeople returns everyone online, and everyone with a session record who isn't online
+// and whose name follows an @ in text. If several offline people had the same name, the one seen most recently is used.
+func mentionablePeople(text string) []mentionable {
+	if !strings.Contains(text, "@") {
+		return nil
+	}
+	online := onlineUsers()
+	people := make([]mentionable, 0, len(online))
+	taken := make(map[string]bool, len(online))
+	for _, us := range online {
+		name := stripansi.Strip(us.name)
+		people = append(people, mentionable{us.id, name, us})
+		taken[name] = true
+	}
+	offline := make(map[string]string) // name to ID
+	sessionsMutex.Lock()
+	for i := 0; i < len(text); i++ {
+		if text[i] != '@' {
+			continue
+		}
+		for end := i + 1; end < len(text) && end-i <= maxLengthName && isPrintableASCII(text[end]); end++ { // every name that could start here
+			name := text[i+1 : end+1]
+			if id, ok := sessionsByName[name]; ok && !taken[name] {
+				offline[name] = id
+			}
+		}
+	}
+	sessionsMutex.Unlock()
+	for name, id := range offline {
+		if _, online := findUserByID(id); !online { // they may be online under another name
+			people = append(people, mentionable{id, name, nil})
+		}
+	}
+	return people
+}
+
+// replaceMentions finds mentions like @bob of people in text, replacing mentions of online users with their colored name.
+// Mentions must start at the beginning of a word and end at the end of one, and can be escaped like \@bob.
+// It returns the new text and the people mentioned.
+func replaceMentions(text string, people []mentionable) (string, []mentionable) {
+	sorted := append(make([]mentionable, 0, len(people)), people...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].name) > len(sorted[j].name)
+	}) // so @bob-2 isn't found as @bob
+	var (
+		b         strings.Builder
+		mentioned []mentionable
+		seen      = make(map[string]bool)
+	)
+	for i := 0; i < len(text); i++ {
+		if text[i] != '@' || (i > 0 && isNameChar(text[i-1])) {
+			b.WriteByte(text[i])
+			continue
+		}
+		escaped := i > 0 && text[i-1] == '\\'
+		found := -1
+		for j, p := range sorted {
+			end := i + 1 + len(p.name)
+			if p.name != "" && strings.HasPrefix(text[i+1:], p.name) && (end == len(text) || !isNameChar(text[end]) || !isNameChar(p.name[len(p.name)-1])) {
+				found = j
+				break
+			}
+		}
+		if found == -1 {
+			b.WriteByte('@')
+			continue
+		}
+		p := sorted[found]
+		switch {
+		case escaped:
+			s := b.String()
+			b.Reset()
+			b.WriteString(s[:len(s)-1]) // drop the backslash
+			b.WriteString("@" + p.name)
+		case p.u != nil:
+			b.WriteString(p.u.name)
+		default:
+			b.WriteString("@" + p.name) // offline users keep the @ since they don't have a color
+		}
+		if !escaped && !seen[p.id] {
+			seen[p.id] = true
+			mentioned = append(mentioned, p)
+		}
+		i += len(p.name)
+	}
+	return b.String(), mentioned
+}
+
+// expandMentions rings everyone for @everyone and replaces mentions of people, like post does.
+// It locks every room, so no room's usersMutex can be held.
+func expandMentions(text string) (string, []mentionable) {
+	text = strings.ReplaceAll(text, "@everyone", green.Paint("everyone\a"))
+	return replaceMentions(text, mentionablePeople(text))
+}
+
+// notifyMention adds a mention to someone's inbox and tells them about it if they're online in another room.
+// If the message is already in their inbox, like when it's edited, the entry is updated instead and it reports false.
+func notifyMention(r *room, m *backlogMessage, to mentionable) bool {
+	if to.id == m.senderID || isIgnoring(to.id, m.senderID) {
+		return false
+	}
+	where := r.name
+	if (to.u != nil && !r.isListedFor(to.u)) || (to.u == nil && !r.isListedForID(to.id)) {
+		where = r.displayName()
+	}
+	mentionsMutex.Lock()
+	inbox := mentions[to.id]
+	for i := range inbox {
+		if inbox[i].MsgID == m.id && inbox[i].FromID == m.senderID && inbox[i].Time.Equal(m.timestamp) {
+			inbox[i].Text = snippet(m.text, 200)
+			mentionsDirty = true
+			mentionsMutex.Unlock()
+			return false
+		}
+	}
+	inbox = append(inbox, mention{m.timestamp, where, stripansi.Strip(m.senderName), m.senderID, m.id, snippet(m.text, 200)})
+	if len(inbox) > maxInboxMentions {
+		inbox = inbox[len(inbox)-maxInboxMentions:]
+	}
+	mentions[to.id] = inbox
+	mentionsDirty = true
+	mentionsMutex.Unlock()
+	if to.u != nil && to.u.room != r {
+		to.u.writeln(devbot, stripansi.Strip(m.senderName)+" mentioned you in "+where+": "+snippet(m.text, 80)+" "+gray.Paint("["+m.id+"]"))
+		to.u.ring()
+	}
+	return true
+}
+
+// ring rings the user's terminal bell if they have it on
+func (u *user) ring() {
+	if u.bell {
+		u.term.Write([]byte("\a"))
+	}
+}
+
+// mentionCount returns how many mentions are in a user's inbox
+func mentionCount(u *user) int {
+	mentionsMutex.Lock()
+	defer mentionsMutex.Unlock()
+	return len(mentions[u.id])
+}
+
+func mentionsCMD(rest string, u *user) {
+	mentionsMutex.Lock()
+	defer mentionsMutex.Unlock()
+	inbox := mentions[u.id]
+	switch rest {
+	case "":
+		if len(inbox) == 0 {
+			u.writeln(devbot, "No mentions :)")
+			return
+		}
+		msg := "Your mentions, newest first:  \n"
+		for i := len(inbox) - 1; i >= 0 && i >= len(inbox)-20; i-- {
+			m := inbox[i]
+			msg += gray.Paint(u.formatTime(m.Time)+" "+m.Room) + " " + m.From + ": " + m.Text + " " + gray.Paint("["+m.MsgID+"]") + "  \n"
+		}
+		if len(inbox) > 20 {
+			msg += "and " + strconv.Itoa(len(inbox)-20) + " older ones.  \n"
+		}
+		u.writeln(devbot, msg+"Run mentions clear to empty your inbox.")
+	case "clear":
+		delete(mentions, u.id)
+		saveMentions()
+		u.writeln(devbot, "Cleared "+strconv.Itoa(len(inbox))+" mentions")
+	default:
+		u.writeln(devbot, "Usage: mentions [clear]")
+	}
+}
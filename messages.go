@@ -0,0 +1,352 @@
+package main
+
+import (
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/acarl005/stripansi"
+)
+
+const messageIDChars = "abcdefghijkmnpqrstuvwxyz23456789" // no l, o, 0 or 1 so IDs are easy to read and type
+
+// newMessageID makes a short ID that isn't used by any message in the room. r.usersMutex must be held.
+func (r *room) newMessageID() string {
+	for {
+		b := make([]byte, 4)
+		for i := range b {
+			b[i] = messageIDChars[rand.Intn(len(messageIDChars))]
+		}
+		id := string(b)
+		if r.findMessageIndex(id) == -1 {
+			return id
+		}
+	}
+}
+
+// findMessageIndex finds a message in the backlog by ID, returning -1 if it isn't found. r.usersMutex must be held.
+func (r *room) findMessageIndex(id string) int {
+	for i := len(r.backlog) - 1; i >= 0; i-- {
+		if r.backlog[i].id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// findMessage returns a copy of the message with the ID, if it's still in the room's history
+func (r *room) findMessage(id string) (backlogMessage, bool) {
+	r.usersMutex.Lock()
+	defer r.usersMutex.Unlock()
+	i := r.findMessageIndex(cleanMessageID(id))
+	if i == -1 {
+		return backlogMessage{}, false
+	}
+	return r.backlog[i], true
+}
+
+// updateMessage calls f on the message with the ID, reporting if it was found
+func (r *room) updateMessage(id string, f func(m *backlogMessage)) bool {
+	r.usersMutex.Lock()
+	defer r.usersMutex.Unlock()
+	i := r.findMessageIndex(cleanMessageID(id))
+	if i == -1 {
+		return false
+	}
+	f(&r.backlog[i])
+	return true
+}
+
+// cleanMessageID lets people refer to messages like [ab2c] as well as ab2c
+func cleanMessageID(id string) string {
+	return strings.ToLower(strings.Trim(id, "[]#"))
+}
+
+// notice sends a message about something a user did to everyone in the room (and Slack, unless it's hidden) without adding it
+// to the backlog. Users ignoring the user don't get it.
+func (r *room) notice(from *user, msg string) {
+	r.sendToSlack(msg)
+	r.usersMutex.Lock()
+	defer r.usersMutex.Unlock()
+	for i := range r.users {
+		if !r.users[i].isIgnoring(from.id) {
+			r.users[i].writeln("", msg)
+		}
+	}
+}
+
+// quoteOf shows the message that m replies to, like "> bob [ab2c]: hi", or returns an empty string if m isn't a reply.
+// r.usersMutex must be held.
+func (r *room) quoteOf(m *backlogMessage) string {
+	if m.replyTo == "" {
+		return ""
+	}
+	i := r.findMessageIndex(m.replyTo)
+	switch {
+	case i == -1:
+		return "> [" + m.replyTo + "]"
+	case r.backlog[i].deleted:
+		return "> [" + m.replyTo + "] deleted"
+	}
+	parent := r.backlog[i]
+	return "> " + stripansi.Strip(parent.senderName) + " [" + parent.id + "]: " + snippet(parent.text, 60)
+}
+
+// writeMessage writes a message from a room's backlog, along with its ID and the quote if it's a reply,
+// unless the user is ignoring the sender
+func (u *user) writeMessage(m *backlogMessage, quote string) {
+	if m.deleted || u.isIgnoring(m.senderID) {
+		return
+	}
+	suffix := ""
+	if m.edited {
+		suffix = gray.Paint("(edited)") + " "
+	}
+	if m.id != "" {
+		suffix += gray.Paint("[" + m.id + "]")
+	}
+	if len(m.reactions) > 0 {
+		suffix += " " + strings.TrimSpace(mdRender(reactionSummary(m.reactions), 0, u.size().Width))
+	}
+	text := m.text
+	if quote != "" {
+		text = quote + "\n\n" + text
+	}
+	u.writelnWithSuffix(m.senderName, text, suffix)
+}
+
+// canChangeMessage reports if a user can edit or delete a message: their own, or any if they're an op
+func canChangeMessage(u *user, m backlogMessage) bool {
+	return (m.senderID != "" && m.senderID == u.id) || u.room.isOp(u)
+}
+
+func editCMD(rest string, u *user) {
+	args := strings.Fields(rest)
+	if len(args) < 2 {
+		u.writeln(devbot, "Usage: edit <message id> <new text>")
+		return
+	}
+	if u.isMuted() {
+		u.writeln(devbot, "You can't edit messages while you're muted")
+		return
+	}
+	if msg := u.room.checkPost(u); msg != "" {
+		u.writeln(devbot, msg)
+		return
+	}
+	m, ok := u.room.findMessage(args[0])
+	if !ok || m.deleted {
+		u.writeln(devbot, "I couldn't find that message. It may be too old.")
+		return
+	}
+	if !canChangeMessage(u, m) {
+		u.writeln(devbot, "You can only edit your own messages")
+		return
+	}
+	u.room.recordPost(u)
+	text, mentioned := expandMentions(strings.TrimSpace(strings.TrimPrefix(rest, args[0])))
+	u.room.updateMessage(m.id, func(m *backlogMessage) {
+		m.text = text + "\n"
+		m.edited = true
+	})
+	recordEdit(u.room, m.id, text)
+	u.room.notice(u, gray.Paint("✎ "+stripansi.Strip(u.name)+" edited ["+m.id+"]:")+" "+text)
+	m.text = text
+	for _, p := range mentioned {
+		if notifyMention(u.room, &m, p) && p.u != nil && p.u.room == u.room {
+			p.u.ring()
+		}
+	}
+}
+
+func deleteCMD(rest string, u *user) {
+	if rest == "" {
+		u.writeln(devbot, "Usage: delete <message id>")
+		return
+	}
+	m, ok := u.room.findMessage(rest)
+	if !ok || m.deleted {
+		u.writeln(devbot, "I couldn't find that message. It may be too old.")
+		return
+	}
+	if !canChangeMessage(u, m) {
+		u.writeln(devbot, "You can only delete your own messages")
+		return
+	}
+	u.room.updateMessage(m.id, func(m *backlogMessage) {
+		m.text = ""
+		m.deleted = true
+	})
+	recordDelete(u.room, m.id)
+	u.room.notice(u, gray.Paint("✗ "+stripansi.Strip(u.name)+" deleted ["+m.id+"]"))
+}
+
+// snippet shortens a message to one line of at most n characters
+func snippet(text string, n int) string {
+	text = strings.Join(strings.Fields(strings.ReplaceAll(stripansi.Strip(text), `\n`, " ")), " ")
+	if len([]rune(text)) > n {
+		text = string([]rune(text)[:n-1]) + "…"
+	}
+	return text
+}
+
+func replyCMD(rest string, u *user) {
+	args := strings.Fields(rest)
+	if len(args) < 2 {
+		u.writeln(devbot, "Usage: reply <message id> <msg>")
+		return
+	}
+	parent, ok := u.room.findMessage(args[0])
+	if !ok || parent.deleted {
+		u.writeln(devbot, "I couldn't find that message. It may be too old.")
+		return
+	}
+	u.room.recordPost(u)
+	u.room.postFrom(u, &backlogMessage{text: strings.TrimSpace(strings.TrimPrefix(rest, args[0])), replyTo: parent.id})
+}
+
+func threadCMD(rest string, u *user) {
+	if rest == "" {
+		u.writeln(devbot, "Usage: thread <message id>")
+		return
+	}
+	root, ok := u.room.findMessage(rest)
+	if !ok {
+		u.writeln(devbot, "I couldn't find that message. It may be too old.")
+		return
+	}
+	for root.replyTo != "" { // find the start of the thread
+		parent, ok := u.room.findMessage(root.replyTo)
+		if !ok {
+			break
+		}
+		root = parent
+	}
+	u.room.usersMutex.Lock()
+	thread := []backlogMessage{root}
+	inThread := map[string]bool{root.id: true}
+	for _, m := range u.room.backlog {
+		if m.replyTo != "" && inThread[m.replyTo] {
+			thread = append(thread, m)
+			inThread[m.id] = true
+		}
+	}
+	u.room.usersMutex.Unlock()
+
+	u.writeln(devbot, "Thread ["+root.id+"] with "+strconv.Itoa(len(thread)-1)+" replies:")
+	for i := range thread {
+		if thread[i].deleted {
+			u.writeln("", gray.Paint("["+thread[i].id+"] deleted"))
+			continue
+		}
+		u.room.usersMutex.Lock()
+		quote := u.room.quoteOf(&thread[i])
+		u.room.usersMutex.Unlock()
+		u.writeMessage(&thread[i], quote)
+	}
+}
+
+var emojiCode = regexp.MustCompile(`^:[a-z0-9_+-]+:$`)
+
+// isEmoji reports if s is an emoji code like :tada: or a short non-ASCII string like 🎉
+func isEmoji(s string) bool {
+	if emojiCode.MatchString(s) {
+		return true
+	}
+	runes := []rune(s)
+	if len(runes) == 0 || len(runes) > 4 {
+		return false
+	}
+	for _, r := range runes {
+		if r < 128 {
+			return false
+		}
+	}
+	return true
+}
+
+// reactionSummary shows reactions like ":+1: 3  :tada: 1"
+func reactionSummary(reactions []reaction) string {
+	parts := make([]string, 0, len(reactions))
+	for _, r := range reactions {
+		parts = append(parts, r.emoji+" "+strconv.Itoa(len(r.userIDs)))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// toggleReaction adds a user's reaction, or removes it if it's already there.
+// The reactions slice is copied so copies of the message aren't changed.
+func toggleReaction(reactions []reaction, emoji string, userID string) (result []reaction, added bool) {
+	result = make([]reaction, 0, len(reactions)+1)
+	found := false
+	for _, r := range reactions {
+		if r.emoji != emoji {
+			result = append(result, r)
+			continue
+		}
+		found = true
+		ids := make([]string, 0, len(r.userIDs)+1)
+		for _, id := range r.userIDs {
+			if id != userID {
+				ids = append(ids, id)
+			}
+		}
+		added = len(ids) == len(r.userIDs)
+		if added {
+			ids = append(ids, userID)
+		}
+		if len(ids) > 0 {
+			result = append(result, reaction{emoji, ids})
+		}
+	}
+	if !found {
+		result = append(result, reaction{emoji, []string{userID}})
+		added = true
+	}
+	return result, added
+}
+
+func reactCMD(rest string, u *user) {
+	args := strings.Fields(rest)
+	if len(args) != 2 || !isEmoji(args[1]) {
+		u.writeln(devbot, "Usage: react <message id> :emoji:")
+		return
+	}
+	if u.isMuted() {
+		u.writeln(devbot, "You can't react while you're muted")
+		return
+	}
+	if msg := u.room.checkPost(u); msg != "" {
+		u.writeln(devbot, msg)
+		return
+	}
+	var (
+		added     bool
+		reactions []reaction
+		id        string
+	)
+	ok := u.room.updateMessage(args[0], func(m *backlogMessage) {
+		if m.deleted {
+			return
+		}
+		m.reactions, added = toggleReaction(m.reactions, args[1], u.id)
+		reactions = m.reactions
+		id = m.id
+	})
+	if !ok || id == "" {
+		u.writeln(devbot, "I couldn't find that message. It may be too old.")
+		return
+	}
+	u.room.recordPost(u)
+	recordReaction(u.room, id, u, args[1])
+	action := " reacted " + args[1] + " to ["
+	if !added {
+		action = " took back " + args[1] + " on ["
+	}
+	summary := ""
+	if len(reactions) > 0 {
+		summary = " · " + reactionSummary(reactions)
+	}
+	u.room.notice(u, gray.Paint(stripansi.Strip(u.name)+action+id+"]")+summary)
+}
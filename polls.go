@@ -0,0 +1,243 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/acarl005/stripansi"
+)
+
+const pollBarWidth = 20
+
+type poll struct {
+	id        int
+	room      *room
+	question  string
+	options   []string
+	votes     map[string]int // user ID to the index of the option they voted for
+	creatorID string
+	creator   string
+	closed    bool
+}
+
+var (
+	polls      = make(map[int]*poll)
+	pollsMutex sync.Mutex
+	lastPollID = 0
+)
+
+// splitQuoted splits s on spaces, keeping text in double quotes together
+func splitQuoted(s string) []string {
+	args := make([]string, 0, 5)
+	current := new(strings.Builder)
+	inQuotes, hasArg := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case unicode.IsSpace(r) && !inQuotes:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// tally shows the votes as a markdown table with bars. pollsMutex must be held.
+func (p *poll) tally() string {
+	counts := make([]int, len(p.options))
+	for _, o := range p.votes {
+		counts[o]++
+	}
+	status := "open, vote with `vote " + strconv.Itoa(p.id) + " <n>`"
+	if p.closed {
+		status = "closed"
+	}
+	msg := "**Poll " + strconv.Itoa(p.id) + ": " + p.question + "** (" + status + ")\n\n" +
+		"| # | Option | Votes | |\n|---|---|---|---|\n"
+	for i, o := range p.options {
+		bar, percent := "", 0
+		if len(p.votes) > 0 {
+			bar = strings.Repeat("█", counts[i]*pollBarWidth/len(p.votes))
+			percent = counts[i] * 100 / len(p.votes)
+		}
+		msg += "| " + strconv.Itoa(i+1) + " | " + strings.ReplaceAll(o, "|", "/") + " | " + strconv.Itoa(counts[i]) + " | " + bar + " " + strconv.Itoa(percent) + "% |\n"
+	}
+	return msg
+}
+
+// winners returns the options with the most votes. pollsMutex must be held.
+func (p *poll) winners() []string {
+	counts := make([]int, len(p.options))
+	best := 0
+	for _, o := range p.votes {
+		counts[o]++
+		if counts[o] > best {
+			best = counts[o]
+		}
+	}
+	w := make([]string, 0, 1)
+	for i, c := range counts {
+		if c == best && best > 0 {
+			w = append(w, p.options[i])
+		}
+	}
+	return w
+}
+
+// findPoll finds a poll in a room by ID, or the room's latest open poll if id is empty. pollsMutex must be held.
+func findPoll(r *room, id string) *poll {
+	if id == "" {
+		var latest *poll
+		for _, p := range polls {
+			if p.room == r && (latest == nil || p.id > latest.id) {
+				latest = p
+			}
+		}
+		return latest
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(id, "#"))
+	if err != nil {
+		return nil
+	}
+	if p, ok := polls[n]; ok && p.room == r {
+		return p
+	}
+	return nil
+}
+
+func pollCMD(rest string, u *user) {
+	args := splitQuoted(rest)
+	if len(args) == 0 {
+		u.writeln(devbot, `Usage: poll "question" <option> <option> ..., poll show [id] or poll close [id]`)
+		return
+	}
+	switch args[0] {
+	case "show", "close":
+		id := ""
+		if len(args) > 1 {
+			id = args[1]
+		}
+		pollsMutex.Lock()
+		p := findPoll(u.room, id)
+		if p == nil {
+			pollsMutex.Unlock()
+			u.writeln(devbot, "I couldn't find that poll in this room")
+			return
+		}
+		if args[0] == "show" {
+			tally := p.tally()
+			pollsMutex.Unlock()
+			u.writeln(devbot, tally)
+			return
+		}
+		if p.creatorID != u.id && !u.room.isOp(u) {
+			pollsMutex.Unlock()
+			u.writeln(devbot, "Only "+p.creator+" or an op can close this poll")
+			return
+		}
+		p.closed = true
+		delete(polls, p.id)
+		result := "Nobody voted."
+		if w := p.winners(); len(w) == 1 {
+			result = "The winner is **" + w[0] + "**!"
+		} else if len(w) > 1 {
+			result = "It's a tie between **" + strings.Join(w, "** and **") + "**!"
+		}
+		tally := p.tally()
+		pollsMutex.Unlock()
+		u.room.broadcast(devbot, stripansi.Strip(u.name)+" closed poll "+strconv.Itoa(p.id)+". "+result+"\n\n"+tally)
+		return
+	}
+
+	if len(args) < 3 {
+		u.writeln(devbot, "A poll needs a question and at least two options")
+		return
+	}
+	if len(args) > 11 {
+		u.writeln(devbot, "Polls can have at most 10 options")
+		return
+	}
+	for _, o := range args {
+		if strings.TrimSpace(o) == "" {
+			u.writeln(devbot, "Poll questions and options can't be empty")
+			return
+		}
+	}
+	if u.isMuted() {
+		u.writeln(devbot, "You can't start polls while you're muted")
+		return
+	}
+	if msg := u.room.checkPost(u); msg != "" {
+		u.writeln(devbot, msg)
+		return
+	}
+	u.room.recordPost(u)
+	pollsMutex.Lock()
+	lastPollID++
+	p := &poll{
+		id:        lastPollID,
+		room:      u.room,
+		question:  args[0],
+		options:   args[1:],
+		votes:     make(map[string]int),
+		creatorID: u.id,
+		creator:   stripansi.Strip(u.name),
+	}
+	polls[p.id] = p
+	tally := p.tally()
+	pollsMutex.Unlock()
+	u.room.broadcast(devbot, p.creator+" started a poll!\n\n"+tally)
+}
+
+func voteCMD(rest string, u *user) {
+	args := strings.Fields(rest)
+	if len(args) != 2 {
+		u.writeln(devbot, "Usage: vote <poll id> <option number>")
+		return
+	}
+	if u.isMuted() {
+		u.writeln(devbot, "You can't vote while you're muted")
+		return
+	}
+	if msg := u.room.checkPost(u); msg != "" {
+		u.writeln(devbot, msg)
+		return
+	}
+	pollsMutex.Lock()
+	p := findPoll(u.room, args[0]) // closed polls are gone
+	if p == nil {
+		pollsMutex.Unlock()
+		u.writeln(devbot, "I couldn't find that poll in this room. It may have been closed.")
+		return
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 1 || n > len(p.options) {
+		pollsMutex.Unlock()
+		u.writeln(devbot, "Pick an option from 1 to "+strconv.Itoa(len(p.options)))
+		return
+	}
+	_, changed := p.votes[u.id]
+	p.votes[u.id] = n - 1
+	msg := "Voted for " + p.options[n-1]
+	if changed {
+		msg = "Changed your vote to " + p.options[n-1]
+	}
+	tally, votes := p.tally(), len(p.votes)
+	pollsMutex.Unlock()
+	u.room.recordPost(u)
+	u.writeln(devbot, msg+"\n\n"+tally)
+	u.room.notice(u, gray.Paint(stripansi.Strip(u.name)+" voted in poll "+strconv.Itoa(p.id)+" ("+strconv.Itoa(votes)+" votes so far, see them with poll show "+strconv.Itoa(p.id)+")"))
+}
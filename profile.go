@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acarl005/stripansi"
+)
+
+const (
+	maxBioLen    = 300
+	maxStatusLen = 60
+
+	profileSaveInterval = 10 * time.Second // profiles change with every line typed, so saves are batched
+)
+
+type profile struct {
+	Bio    string `json:",omitempty"`
+	Status string `json:",omitempty"` // a short line like ":palm_tree: on vacation"
+
+	History []string `json:",omitempty"` // lines they've typed, oldest first
+	TUI     bool     `json:",omitempty"` // start in full-screen mode
+}
+
+var (
+	profiles      = make(map[string]*profile) // user ID to profile
+	profilesMutex sync.Mutex
+	profilesDirty bool // set if profiles changed since they were last saved
+)
+
+func init() {
+	if err := loadJSON("profiles.json", &profiles); err != nil {
+		l.Println("Error reading profiles:", err)
+	}
+}
+
+// getProfile returns a copy of a user's profile
+func getProfile(id string) profile {
+	profilesMutex.Lock()
+	defer profilesMutex.Unlock()
+	if p, ok := profiles[id]; ok {
+		return *p
+	}
+	return profile{}
+}
+
+// updateProfile changes a user's profile. It's saved by runProfileSaves soon after.
+func updateProfile(id string, f func(p *profile)) {
+	profilesMutex.Lock()
+	defer profilesMutex.Unlock()
+	p, ok := profiles[id]
+	if !ok {
+		p = new(profile)
+		profiles[id] = p
+	}
+	f(p)
+	if p.Bio == "" && p.Status == "" && len(p.History) == 0 && !p.TUI {
+		delete(profiles, id)
+	}
+	profilesDirty = true
+}
+
+// runProfileSaves saves profiles when they've changed, at most once every profileSaveInterval
+func runProfileSaves() {
+	for range time.Tick(profileSaveInterval) {
+		saveProfiles()
+	}
+}
+
+// saveProfiles saves profiles if they've changed since they were last saved
+func saveProfiles() {
+	profilesMutex.Lock()
+	defer profilesMutex.Unlock()
+	if profilesDirty {
+		if err := saveJSON("profiles.json", profiles); err != nil {
+			l.Println("Error saving profiles:", err)
+		}
+		profilesDirty = false
+	}
+}
+
+// setProfileText handles the bio and status commands, which show, clear or set a field
+func setProfileText(rest string, u *user, field string, maxLen int, f func(p *profile) *string) {
+	p := getProfile(u.id)
+	current := *f(&p)
+	switch rest {
+	case "":
+		if current == "" {
+			u.writeln(devbot, "You don't have a "+field+". Set one with "+field+" set <text>")
+			return
+		}
+		u.writeln(devbot, "Your "+field+": "+current)
+		return
+	case "clear":
+		updateProfile(u.id, func(p *profile) { *f(p) = "" })
+		u.writeln(devbot, "Cleared your "+field)
+		return
+	}
+	if !strings.HasPrefix(rest, "set ") {
+		u.writeln(devbot, "Usage: "+field+" [set <text>|clear]")
+		return
+	}
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "set "))
+	rest = strings.ReplaceAll(stripansi.Strip(rest), `\n`, " ") // keep it to one line
+	if n := len([]rune(rest)); n > maxLen {
+		u.writeln(devbot, "That "+field+" is too long ("+strconv.Itoa(n)+" characters). Keep it under "+strconv.Itoa(maxLen)+".")
+		return
+	}
+	updateProfile(u.id, func(p *profile) { *f(p) = rest })
+	u.writeln(devbot, "Set your "+field+" to: "+rest)
+}
+
+func bioCMD(rest string, u *user) {
+	setProfileText(rest, u, "bio", maxBioLen, func(p *profile) *string { return &p.Bio })
+}
+
+func statusCMD(rest string, u *user) {
+	setProfileText(rest, u, "status", maxStatusLen, func(p *profile) *string { return &p.Status })
+}
+
+// printStatusesInRoom lists the users in a room with their status lines
+func printStatusesInRoom(r *room) string {
+	r.usersMutex.Lock()
+	users := append(make([]*user, 0, len(r.users)), r.users...)
+	r.usersMutex.Unlock()
+	sort.Slice(users, func(i, j int) bool { return stripansi.Strip(users[i].name) < stripansi.Strip(users[j].name) })
+	lines := make([]string, 0, len(users))
+	for _, us := range users {
+		line := us.name
+		if us.isAway() {
+			line += gray.Paint("(away)")
+		}
+		if status := getProfile(us.id).Status; status != "" {
+			line += " " + status
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "  \n")
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit configures a token bucket: it holds up to Burst tokens and gains Rate tokens a second.
+// A Burst of 0 or less disables the limit.
+type rateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst float64 `yaml:"burst"`
+}
+
+type rateLimitConfig struct {
+	UserMessages rateLimit `yaml:"user_messages"` // keyed by user ID
+	IPMessages   rateLimit `yaml:"ip_messages"`
+	RoomMessages rateLimit `yaml:"room_messages"`
+	UserLogins   rateLimit `yaml:"user_logins"`
+	IPLogins     rateLimit `yaml:"ip_logins"`
+
+	StrikesToMute int    `yaml:"strikes_to_mute"` // how many times a user can go over a limit before being muted
+	StrikesToBan  int    `yaml:"strikes_to_ban"`
+	MuteFor       string `yaml:"mute_for"`
+	StrikesExpire string `yaml:"strikes_expire"` // strikes are forgotten if there aren't any new ones for this long
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	burst  float64
+}
+
+type strikes struct {
+	count int
+	last  time.Time
+	name  string
+}
+
+type limiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	strikes map[string]*strikes // user ID to strikes
+}
+
+var limits = &limiter{buckets: make(map[string]*tokenBucket), strikes: make(map[string]*strikes)}
+
+// take takes a token from the bucket for key, reporting if there was one
+func (lim *limiter) take(key string, rl rateLimit) bool {
+	if rl.Burst <= 0 {
+		return true
+	}
+	lim.mutex.Lock()
+	defer lim.mutex.Unlock()
+	now := time.Now()
+	b, ok := lim.buckets[key]
+	if !ok {
+		if len(lim.buckets) > 10000 {
+			lim.sweep(now)
+		}
+		b = &tokenBucket{tokens: rl.Burst, last: now}
+		lim.buckets[key] = b
+	}
+	b.burst = rl.Burst
+	b.tokens = math.Min(rl.Burst, b.tokens+now.Sub(b.last).Seconds()*rl.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep forgets buckets that have probably refilled and strikes that have expired. lim.mutex must be held.
+func (lim *limiter) sweep(now time.Time) {
+	for k, b := range lim.buckets {
+		if now.Sub(b.last) > 10*time.Minute {
+			delete(lim.buckets, k)
+		}
+	}
+	expire := parseDurationOr(getConfig().RateLimits.StrikesExpire, 10*time.Minute)
+	for k, s := range lim.strikes {
+		if now.Sub(s.last) > expire {
+			delete(lim.strikes, k)
+		}
+	}
+}
+
+// strike records that a user went over a limit and returns how many recent strikes they have
+func (lim *limiter) strike(u *user) int {
+	lim.mutex.Lock()
+	defer lim.mutex.Unlock()
+	now := time.Now()
+	s, ok := lim.strikes[u.id]
+	if !ok || now.Sub(s.last) > parseDurationOr(getConfig().RateLimits.StrikesExpire, 10*time.Minute) {
+		s = &strikes{}
+		lim.strikes[u.id] = s
+	}
+	s.count++
+	s.last = now
+	s.name = u.name
+	return s.count
+}
+
+// allowMessage checks the user and IP limits for a line of input. Going over the user limit escalates from
+// slowing the user down to muting them to banning them. Lines that post to the room are then checked against the room's limit.
+// It returns false if the line should be dropped.
+func allowMessage(u *user, line string) bool {
+	rl := getConfig().RateLimits
+	if !limits.take("user:"+u.id, rl.UserMessages) {
+		if !u.isMuted() { // muted users can't post anyway, so don't pile up strikes into a ban
+			punishSpam(u)
+		}
+		return false
+	}
+	if !limits.take("ip:"+u.addr, rl.IPMessages) {
+		// Many people can share an IP, so this may be someone else's traffic and doesn't count as a strike
+		u.writeln(devbot, "Too many messages are coming from your network right now, try again in a bit")
+		return false
+	}
+	if postsToRoom(u, line) && !limits.take("room:"+u.room.name, rl.RoomMessages) {
+		// The user is within their own limits, so this isn't their fault and doesn't count as a strike
+		u.writeln(devbot, "This room is really busy right now, try again in a bit")
+		return false
+	}
+	return true
+}
+
+// postsToRoom reports if a line would be posted in u's room. Admins, commands and DMs aren't limited by the room.
+func postsToRoom(u *user, line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || auth(u) || u.isMuted() || u.messaging != nil || strings.HasPrefix(line, "=") {
+		return false
+	}
+	return isMessage(line, u)
+}
+
+// punishSpam gives a user a strike for going over a limit and mutes or bans them if they have too many
+func punishSpam(u *user) {
+	rl := getConfig().RateLimits
+	count := limits.strike(u)
+	switch {
+	case rl.StrikesToBan > 0 && count >= rl.StrikesToBan:
+		addBan(u.addr, u.id)
+		saveBans()
+		u.writeln(devbot, "anti-spam triggered")
+		u.close(red.Paint(u.name + " has been banned for spamming"))
+	case rl.StrikesToMute > 0 && count >= rl.StrikesToMute:
+		d := parseDurationOr(rl.MuteFor, time.Minute)
+		u.mute(d)
+		u.room.broadcast(devbot, u.name+" has been muted for "+d.String()+" for spamming")
+	default:
+		u.writeln(devbot, "Slow down! Keep this up and you'll get muted.")
+	}
+}
+
+// allowLogin checks the login limits for a new connection
+func allowLogin(u *user) bool {
+	rl := getConfig().RateLimits
+	return limits.take("login-user:"+u.id, rl.UserLogins) && limits.take("login-ip:"+u.addr, rl.IPLogins)
+}
+
+// status describes buckets that aren't full, strikes and mutes
+func (lim *limiter) status() string {
+	lim.mutex.Lock()
+	defer lim.mutex.Unlock()
+	now := time.Now()
+	lines := make([]string, 0, len(lim.buckets))
+	for k, b := range lim.buckets {
+		if b.tokens < b.burst && now.Sub(b.last) < time.Minute {
+			lines = append(lines, fmt.Sprintf("%v: %.1f/%v tokens", k, b.tokens, b.burst))
+		}
+	}
+	sort.Strings(lines)
+	strikeLines := make([]string, 0, len(lim.strikes))
+	for id, s := range lim.strikes {
+		strikeLines = append(strikeLines, s.name+" ("+shortID(id)+"): "+strconv.Itoa(s.count)+" strikes, last "+printPrettyDuration(now.Sub(s.last))+" ago")
+	}
+	sort.Strings(strikeLines)
+	mutedLines := make([]string, 0)
+	for _, r := range allRooms() {
+		for _, us := range r.users {
+			if us.isMuted() {
+				mutedLines = append(mutedLines, us.name+" muted for "+printPrettyDuration(time.Until(us.mutedUntil)))
+			}
+		}
+	}
+	if len(lines)+len(strikeLines)+len(mutedLines) == 0 {
+		return "Nobody is being limited right now"
+	}
+	return "Limited buckets:  \n" + strings.Join(lines, "  \n") +
+		"  \nStrikes:  \n" + strings.Join(strikeLines, "  \n") +
+		"  \nMuted:  \n" + strings.Join(mutedLines, "  \n")
+}
+
+func parseDurationOr(s string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fallback
+	}
+	return d
+}
+
+func limitsCMD(_ string, u *user) {
+	if !auth(u) {
+		u.room.broadcast(devbot, "Not authorized")
+		return
+	}
+	u.writeln(devbot, limits.status())
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// reloadMutex makes sure only one reload swaps in new settings at a time
+var reloadMutex sync.Mutex
+
+// reload re-reads the config, admins, bans, art and filter files. Either everything is
+// replaced or, if any file fails to load, nothing is. It returns a summary of what changed.
+func reload() (string, error) {
+	newConfig, err := loadConfig(configFile)
+	if err != nil {
+		return "", fmt.Errorf("couldn't load %v: %v", configFile, err)
+	}
+	newAdmins, err := readAdmins()
+	if err != nil {
+		return "", err
+	}
+	newBans, err := loadBans()
+	if err != nil {
+		return "", fmt.Errorf("couldn't load bans.json: %v", err)
+	}
+	newArt := getASCIIArt()
+	newFilterRules, err := loadFilterRules(newConfig.FilterFile)
+	if err != nil {
+		return "", fmt.Errorf("couldn't load filter rules from %v: %v", newConfig.FilterFile, err)
+	}
+
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	old := getSettings()
+
+	changes := make([]string, 0, 5)
+	changes = append(changes, diffConfig(old.config, newConfig)...)
+	keepStartupConfig(&newConfig, old.config)
+	changes = append(changes, diffAdmins(old.admins, newAdmins)...)
+	bansMutex.Lock()
+	if len(newBans) != len(bans) {
+		changes = append(changes, "bans: "+strconv.Itoa(len(bans))+" → "+strconv.Itoa(len(newBans)))
+	}
+	bans = newBans
+	bansMutex.Unlock()
+	if newArt != old.art {
+		changes = append(changes, "art changed")
+	}
+	if !filterRulesEqual(old.filterRules, newFilterRules) {
+		changes = append(changes, "filter rules: "+strconv.Itoa(len(old.filterRules))+" → "+strconv.Itoa(len(newFilterRules)))
+	}
+
+	currentSettings.Store(&settings{config: newConfig, admins: newAdmins, art: newArt, filterRules: newFilterRules})
+
+	if len(changes) == 0 {
+		return "nothing changed", nil
+	}
+	return strings.Join(changes, "  \n"), nil
+}
+
+// restartKeys are the config keys that are only read when the server starts
+var restartKeys = map[string]bool{
+	"ssh_port":     true,
+	"profile_port": true,
+	"export_port":  true,
+	"data_dir":     true,
+	"key_file":     true,
+	"creds_file":   true,
+}
+
+// keepStartupConfig copies the values of the keys in restartKeys from the running config, so the config keeps
+// saying what's in use until the server restarts
+func keepStartupConfig(c *config, running config) {
+	c.SSHPort = running.SSHPort
+	c.ProfilePort = running.ProfilePort
+	c.ExportPort = running.ExportPort
+	c.DataDir = running.DataDir
+	c.KeyFile = running.KeyFile
+	c.CredsFile = running.CredsFile
+}
+
+// diffConfig lists the config keys whose values differ, noting the ones that need a restart
+func diffConfig(oldConfig, newConfig config) []string {
+	toMap := func(c config) map[string]string {
+		d, _ := yaml.Marshal(c)
+		m := make(map[string]interface{})
+		yaml.Unmarshal(d, &m) //nolint:errcheck // we just marshalled this
+		result := make(map[string]string, len(m))
+		for k, v := range m {
+			result[k] = fmt.Sprint(v)
+		}
+		return result
+	}
+	oldMap, newMap := toMap(oldConfig), toMap(newConfig)
+	changes := make([]string, 0)
+	for k, v := range newMap {
+		if oldMap[k] == v {
+			continue
+		}
+		if restartKeys[k] {
+			changes = append(changes, "config "+k+": "+oldMap[k]+" → "+v+" (not applied, needs a restart)")
+		} else {
+			changes = append(changes, "config "+k+": "+oldMap[k]+" → "+v)
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// diffAdmins lists admins that were added or removed
+func diffAdmins(oldAdmins, newAdmins map[string]string) []string {
+	changes := make([]string, 0)
+	for id, info := range newAdmins {
+		if _, ok := oldAdmins[id]; !ok {
+			changes = append(changes, "admin added: "+info+" ("+shortID(id)+")")
+		}
+	}
+	for id, info := range oldAdmins {
+		if _, ok := newAdmins[id]; !ok {
+			changes = append(changes, "admin removed: "+info+" ("+shortID(id)+")")
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// filterRulesEqual reports if two lists of rules were loaded from the same settings
+func filterRulesEqual(a, b []filterRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Pattern != b[i].Pattern || a[i].Word != b[i].Word || a[i].Action != b[i].Action ||
+			a[i].MuteFor != b[i].MuteFor || !stringsEqual(a[i].Rooms, b[i].Rooms) {
+			return false
+		}
+	}
+	return true
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func shortID(id string) string {
+	if len(id) > 10 {
+		return id[:10]
+	}
+	return id
+}
+
+// reloadAndReport reloads and tells the admins in #main what happened. The report is also returned.
+func reloadAndReport(reason string) string {
+	summary, err := reload()
+	if err != nil {
+		l.Println("Reload ("+reason+") failed:", err)
+		report := "Reload (" + reason + ") failed, nothing was changed: " + err.Error()
+		writeToAdmins(mainRoom, report)
+		return report
+	}
+	l.Println("Reloaded (" + reason + "): " + strings.ReplaceAll(summary, "  \n", ", "))
+	report := "Reloaded (" + reason + "):  \n" + summary
+	writeToAdmins(mainRoom, report)
+	return report
+}
+
+// writeToAdmins sends a devbot message only to the admins in a room
+func writeToAdmins(r *room, msg string) {
+	r.usersMutex.Lock()
+	defer r.usersMutex.Unlock()
+	for _, us := range r.users {
+		if auth(us) {
+			us.writeln(devbot, msg)
+		}
+	}
+}
+
+func reloadCMD(_ string, u *user) {
+	if !auth(u) {
+		u.room.broadcast(devbot, "Not authorized")
+		return
+	}
+	report := reloadAndReport("by " + u.name)
+	if u.room != mainRoom {
+		u.writeln(devbot, report)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acarl005/stripansi"
+)
+
+type reminder struct {
+	ID        int
+	CreatorID string
+	Creator   string
+	Target    string // "me" for a DM to the creator, or a room name
+	Text      string
+	Next      time.Time
+	Every     string // empty for one-off reminders, a duration like 2h, or day, weekday or a day of the week
+	At        string // the time of day for daily and weekly reminders, like 09:55
+	Timezone  string // the creator's timezone when they made the reminder
+}
+
+const (
+	maxRemindersPerUser  = 20
+	minRoomReminderEvery = time.Hour // so recurring reminders can't flood a room. Ops can repeat them more often.
+)
+
+var (
+	reminders      = make([]*reminder, 0, 10)
+	remindersMutex sync.Mutex
+)
+
+func init() {
+	if err := loadJSON("reminders.json", &reminders); err != nil {
+		l.Println("Error reading reminders:", err)
+	}
+}
+
+// saveReminders saves reminders to the data directory. remindersMutex must be held.
+func saveReminders() {
+	if err := saveJSON("reminders.json", reminders); err != nil {
+		l.Println("Error saving reminders:", err)
+	}
+}
+
+// runReminders delivers reminders when they're due. They're copied out first so remindersMutex isn't held while delivering.
+func runReminders() {
+	for range time.Tick(5 * time.Second) {
+		now := time.Now()
+		remindersMutex.Lock()
+		due, copies := make([]*reminder, 0), make([]reminder, 0)
+		for _, rem := range reminders {
+			if !rem.Next.After(now) {
+				due = append(due, rem)
+				copies = append(copies, *rem)
+			}
+		}
+		remindersMutex.Unlock()
+		sent := make(map[*reminder]bool, len(due))
+		for i := range copies {
+			if copies[i].deliver() {
+				sent[due[i]] = true
+			}
+		}
+		if len(sent) == 0 {
+			continue
+		}
+		remindersMutex.Lock()
+		kept := reminders[:0]
+		for _, rem := range reminders { // reminders may have been cancelled meanwhile
+			if !sent[rem] {
+				kept = append(kept, rem)
+				continue
+			}
+			if rem.Every == "" {
+				continue
+			}
+			rem.Next = rem.nextAfter(now)
+			kept = append(kept, rem)
+		}
+		reminders = kept
+		saveReminders()
+		remindersMutex.Unlock()
+	}
+}
+
+// deliver sends a reminder, reporting if it was sent. Reminders for people who aren't online wait until they are,
+// and room reminders follow the room's rules as if their creator posted them.
+func (rem *reminder) deliver() bool {
+	if rem.Target == "me" {
+		u, ok := findUserByID(rem.CreatorID)
+		if !ok {
+			return false
+		}
+		u.writeln(devbot+" -> ", "⏰ Reminder: "+rem.Text)
+		return true
+	}
+	r, ok := getRoom(rem.Target)
+	if !ok {
+		l.Println("Skipping reminder", rem.ID, "for", rem.Target, "since the room is gone")
+		return true
+	}
+	if r.readOnly && !r.isOpID(rem.CreatorID) {
+		l.Println("Skipping reminder", rem.ID, "for", rem.Target, "since the room is read-only")
+		return true
+	}
+	if creator, online := findUserByID(rem.CreatorID); r.checkPostID(rem.CreatorID) != "" || (online && creator.isMuted()) {
+		return false // slow mode or a mute, so wait until they could post it themselves
+	}
+	r.broadcast(devbot, "⏰ "+rem.Text+" "+gray.Paint("(reminder from "+rem.Creator+")"))
+	r.recordPostID(rem.CreatorID)
+	return true
+}
+
+func (rem *reminder) location() *time.Location {
+	loc, err := time.LoadLocation(rem.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// nextAfter finds when a recurring reminder should next go off after t
+func (rem *reminder) nextAfter(t time.Time) time.Time {
+	if d, err := parseDuration(rem.Every); err == nil {
+		next := rem.Next
+		for !next.After(t) {
+			next = next.Add(d)
+		}
+		return next
+	}
+	next, _ := nextTimeOfDay(rem.At, rem.Every, t.In(rem.location()))
+	return next
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+// nextTimeOfDay finds the first time after t (in t's location) at the time of day
+// that's on the right days: "day" or "" for any day, "weekday", or a day of the week
+func nextTimeOfDay(at string, days string, t time.Time) (time.Time, error) {
+	clock, err := parseClock(at)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if _, ok := weekdays[days]; !ok && days != "" && days != "day" && days != "weekday" {
+		return time.Time{}, fmt.Errorf("I don't know how to repeat every %q. Try day, weekday, monday, 2h or 1d.", days)
+	}
+	for i := 0; i <= 8; i++ {
+		day := t.AddDate(0, 0, i)
+		next := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, t.Location())
+		if !next.After(t) {
+			continue
+		}
+		wd := next.Weekday()
+		if w, ok := weekdays[days]; (ok && wd != w) || (days == "weekday" && (wd == time.Saturday || wd == time.Sunday)) {
+			continue
+		}
+		return next, nil
+	}
+	return time.Time{}, fmt.Errorf("couldn't find the next %v at %v", days, at) // shouldn't happen
+}
+
+// parseClock parses times of day like 09:55, 9:55am or 3pm
+func parseClock(s string) (time.Time, error) {
+	for _, layout := range []string{"15:04", "3:04pm", "3pm", "3:04PM", "3PM"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("I couldn't understand the time %q. Use something like 09:55 or 3pm.", s)
+}
+
+// parseDuration is like time.ParseDuration but also understands days, like 2d
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("the duration %v isn't positive", s)
+	}
+	return d, err
+}
+
+// parseReminder parses reminders like "me in 30m check deploy", "#standup at 09:55 standup in 5" or
+// "me every weekday at 9am stretch". Times are in loc.
+func parseReminder(rest string, loc *time.Location) (*reminder, error) {
+	args := strings.Fields(rest)
+	usage := errors.New("Usage: remind <me|#room> <in 30m|at 09:55|every <day|weekday|monday|2h> [at 09:55]> <message>")
+	if len(args) < 4 {
+		return nil, usage
+	}
+	rem := &reminder{Target: args[0], Timezone: loc.String()}
+	if rem.Target != "me" && !strings.HasPrefix(rem.Target, "#") {
+		return nil, usage
+	}
+	now := time.Now().In(loc)
+	textStart := 3
+	var err error
+	switch strings.ToLower(args[1]) {
+	case "in":
+		var d time.Duration
+		if d, err = parseDuration(args[2]); err != nil {
+			return nil, fmt.Errorf("I couldn't understand how long %q is. Use something like 30m, 1h30m or 2d.", args[2])
+		}
+		rem.Next = now.Add(d)
+	case "at":
+		rem.At = args[2]
+		rem.Next, err = nextTimeOfDay(rem.At, "", now)
+	case "every":
+		rem.Every = strings.ToLower(args[2])
+		if d, err := parseDuration(rem.Every); err == nil {
+			if d < time.Minute {
+				return nil, errors.New("Reminders can't repeat more than once a minute")
+			}
+			rem.Next = now.Add(d)
+			break
+		}
+		if len(args) < 6 || strings.ToLower(args[3]) != "at" {
+			return nil, fmt.Errorf("Say when to send it, like remind %v every %v at 09:55 <message>", args[0], args[2])
+		}
+		rem.At = args[4]
+		rem.Next, err = nextTimeOfDay(rem.At, rem.Every, now)
+		textStart = 5
+	default:
+		return nil, usage
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(args) <= textStart {
+		return nil, usage
+	}
+	rem.Text = strings.Join(args[textStart:], " ")
+	return rem, nil
+}
+
+func (rem *reminder) describe(u *user) string {
+	s := cyan.Paint("#"+strconv.Itoa(rem.ID)) + " to " + rem.Target + ", next " + u.formatTime(rem.Next)
+	if rem.Every != "" {
+		s += ", every " + rem.Every
+		if rem.At != "" {
+			s += " at " + rem.At
+		}
+	}
+	return s + ": " + rem.Text
+}
+
+func remindCMD(rest string, u *user) {
+	loc := u.localTime(time.Now()).Location()
+	rem, err := parseReminder(rest, loc)
+	if err != nil {
+		u.writeln(devbot, err.Error())
+		return
+	}
+	if rem.Target != "me" {
+		r, ok := getRoom(rem.Target)
+		if !ok || !r.isListedFor(u) {
+			u.writeln(devbot, "I couldn't find the room "+rem.Target)
+			return
+		}
+		if u.isMuted() || (r.readOnly && !r.isOp(u)) {
+			u.writeln(devbot, "You can't post in "+rem.Target)
+			return
+		}
+		if d, err := parseDuration(rem.Every); err == nil && d < minRoomReminderEvery && !r.isOp(u) {
+			u.writeln(devbot, "Reminders to rooms can't repeat more than once every "+printPrettyDuration(minRoomReminderEvery))
+			return
+		}
+	}
+	rem.CreatorID = u.id
+	rem.Creator = stripansi.Strip(u.name)
+
+	remindersMutex.Lock()
+	rem.ID = 1
+	count := 0
+	for _, other := range reminders {
+		if other.ID >= rem.ID {
+			rem.ID = other.ID + 1
+		}
+		if other.CreatorID == u.id {
+			count++
+		}
+	}
+	if count >= maxRemindersPerUser && !auth(u) {
+		remindersMutex.Unlock()
+		u.writeln(devbot, "You already have "+strconv.Itoa(count)+" reminders. Cancel some with reminders cancel <id> first.")
+		return
+	}
+	reminders = append(reminders, rem)
+	saveReminders()
+	remindersMutex.Unlock()
+	who := rem.Target
+	if who == "me" {
+		who = "you"
+	}
+	u.writeln(devbot, "Okay, I'll remind "+who+" at "+u.formatTime(rem.Next)+". Cancel with reminders cancel "+strconv.Itoa(rem.ID))
+}
+
+func remindersCMD(rest string, u *user) {
+	args := strings.Fields(rest)
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	remindersMutex.Lock()
+	defer remindersMutex.Unlock()
+	switch args[0] {
+	case "list":
+		mine := make([]*reminder, 0, len(reminders))
+		for _, rem := range reminders {
+			if rem.CreatorID == u.id {
+				mine = append(mine, rem)
+			}
+		}
+		if len(mine) == 0 {
+			u.writeln(devbot, "You don't have any reminders. Make one with remind me in 30m <message>")
+			return
+		}
+		sort.Slice(mine, func(i, j int) bool { return mine[i].Next.Before(mine[j].Next) })
+		msg := "Your reminders:  \n"
+		for _, rem := range mine {
+			msg += rem.describe(u) + "  \n"
+		}
+		u.writeln(devbot, msg)
+	case "cancel":
+		if len(args) < 2 {
+			u.writeln(devbot, "Usage: reminders cancel <id>")
+			return
+		}
+		id, _ := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		for i, rem := range reminders {
+			if rem.ID == id && (rem.CreatorID == u.id || auth(u)) {
+				reminders = append(reminders[:i], reminders[i+1:]...)
+				saveReminders()
+				u.writeln(devbot, "Cancelled reminder #"+strconv.Itoa(id))
+				return
+			}
+		}
+		u.writeln(devbot, "You don't have a reminder #"+args[1])
+	default:
+		u.writeln(devbot, "Usage: reminders [list|cancel <id>]")
+	}
+}
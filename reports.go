@@ -0,0 +1,188 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acarl005/stripansi"
+)
+
+type report struct {
+	ID         int
+	Time       time.Time
+	Room       string
+	Reason     string
+	ReporterID string
+	Reporter   string
+
+	Name     string // the reported user's name
+	UserID   string
+	Addr     string
+	Messages []reportedMessage // the room's backlog when the report was made
+
+	Resolved   bool
+	ResolvedBy string
+	ResolvedAt time.Time
+	Note       string
+}
+
+type reportedMessage struct {
+	Time   time.Time
+	Sender string
+	Text   string
+}
+
+var (
+	reports      = make([]*report, 0, 10)
+	reportsMutex sync.Mutex
+)
+
+func init() {
+	if err := loadJSON("reports.json", &reports); err != nil {
+		l.Println("Error reading reports:", err)
+	}
+}
+
+func saveReports() {
+	if err := saveJSON("reports.json", reports); err != nil {
+		l.Println("Error saving reports:", err)
+	}
+}
+
+// canSeeReport reports if a user can moderate a report: admins can see every report, ops only those from their rooms.
+func canSeeReport(u *user, rep *report) bool {
+	if auth(u) {
+		return true
+	}
+	if r, ok := getRoom(rep.Room); ok {
+		return r.isOp(u)
+	}
+	return false
+}
+
+func reportCMD(rest string, u *user) {
+	args := strings.Fields(rest)
+	if len(args) < 2 {
+		u.writeln(devbot, "Usage: report <user> <reason>")
+		return
+	}
+	victim, ok := findUserByName(u.room, strings.TrimPrefix(args[0], "@"))
+	if !ok {
+		u.writeln(devbot, "User not found (they need to be in your room)")
+		return
+	}
+	rep := &report{
+		Time:       time.Now(),
+		Room:       u.room.name,
+		Reason:     strings.TrimSpace(strings.TrimPrefix(rest, args[0])),
+		ReporterID: u.id,
+		Reporter:   stripansi.Strip(u.name),
+		Name:       stripansi.Strip(victim.name),
+		UserID:     victim.id,
+		Addr:       victim.addr,
+	}
+	for _, m := range u.room.getBacklog() {
+		rep.Messages = append(rep.Messages, reportedMessage{m.timestamp, stripansi.Strip(m.senderName), stripansi.Strip(strings.TrimSpace(m.text))})
+	}
+
+	reportsMutex.Lock()
+	rep.ID = 1
+	if len(reports) > 0 {
+		rep.ID = reports[len(reports)-1].ID + 1
+	}
+	reports = append(reports, rep)
+	saveReports()
+	reportsMutex.Unlock()
+
+	u.writeln(devbot, "Thanks, your report was sent to the moderators.")
+	l.Println("Report #"+strconv.Itoa(rep.ID)+" by", rep.Reporter, "["+u.id+"] against", rep.Name, "["+rep.UserID+"]:", rep.Reason)
+	notified := false
+	for _, r := range allRooms() {
+		for _, us := range r.users {
+			if canSeeReport(us, rep) {
+				us.writeln(devbot, "New report #"+strconv.Itoa(rep.ID)+": "+rep.Reporter+" reported "+rep.Name+" in "+rep.Room+": "+rep.Reason+"  \nRun reports list to see open reports.")
+				notified = true
+			}
+		}
+	}
+	if !notified {
+		l.Println("No moderators are online to see report #" + strconv.Itoa(rep.ID))
+	}
+}
+
+func reportsCMD(rest string, u *user) {
+	args := strings.Fields(rest)
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	if !isModerator(u) {
+		u.writeln(devbot, "Not authorized. Only moderators can see reports.")
+		return
+	}
+	reportsMutex.Lock()
+	defer reportsMutex.Unlock()
+	switch args[0] {
+	case "list":
+		msg := ""
+		for _, rep := range reports {
+			if rep.Resolved || !canSeeReport(u, rep) {
+				continue
+			}
+			msg += cyan.Paint("#"+strconv.Itoa(rep.ID)) + " " + printPrettyDuration(time.Since(rep.Time)) + " ago in " + rep.Room + ": " +
+				rep.Reporter + " reported " + rep.Name + " (" + shortID(rep.UserID) + ", " + rep.Addr + "): " + rep.Reason + "  \n"
+		}
+		if msg == "" {
+			u.writeln(devbot, "No open reports :)")
+			return
+		}
+		u.writeln(devbot, "Open reports:  \n"+msg+"Use reports show <id> to see messages and reports resolve <id> [note] to close one.")
+	case "show":
+		rep := findReport(args)
+		if rep == nil || !canSeeReport(u, rep) {
+			u.writeln(devbot, "Usage: reports show <id>")
+			return
+		}
+		msg := "Report #" + strconv.Itoa(rep.ID) + " against " + rep.Name + " [" + rep.UserID + "] from " + rep.Addr + " in " + rep.Room + ": " + rep.Reason + "  \n"
+		if rep.Resolved {
+			msg += "Resolved by " + rep.ResolvedBy + " " + printPrettyDuration(time.Since(rep.ResolvedAt)) + " ago: " + rep.Note + "  \n"
+		}
+		msg += "Messages:  \n"
+		for _, m := range rep.Messages {
+			msg += m.Time.UTC().Format("15:04") + " " + m.Sender + ": " + m.Text + "  \n"
+		}
+		u.writeln(devbot, msg)
+	case "resolve":
+		rep := findReport(args)
+		if rep == nil || !canSeeReport(u, rep) {
+			u.writeln(devbot, "Usage: reports resolve <id> [note]")
+			return
+		}
+		rep.Resolved = true
+		rep.ResolvedBy = stripansi.Strip(u.name)
+		rep.ResolvedAt = time.Now()
+		rep.Note = strings.Join(args[2:], " ")
+		saveReports()
+		u.writeln(devbot, "Resolved report #"+strconv.Itoa(rep.ID))
+	default:
+		u.writeln(devbot, "Usage: reports [list|show <id>|resolve <id> [note]]")
+	}
+}
+
+// findReport finds the report with the ID in args[1]. reportsMutex must be held.
+func findReport(args []string) *report {
+	if len(args) < 2 {
+		return nil
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+	if err != nil {
+		return nil
+	}
+	for _, rep := range reports {
+		if rep.ID == id {
+			return rep
+		}
+	}
+	return nil
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acarl005/stripansi"
+)
+
+// room visibilities
+const (
+	visibilityPublic   = "public"   // listed everywhere, anyone can join
+	visibilityUnlisted = "unlisted" // hidden from lists, anyone who knows the name can join
+	visibilityInvite   = "invite"   // hidden, only invited users can join
+	visibilityPassword = "password" // hidden, users need the password or an invite to join
+)
+
+func newRoom(name string) *room {
+	key := make([]byte, 8)
+	rand.Read(key) //nolint:errcheck // crypto/rand doesn't fail on supported platforms
+	return &room{
+		name:       name,
+		key:        hex.EncodeToString(key),
+		users:      make([]*user, 0, 10),
+		ops:        make(map[string]string),
+		lastPost:   make(map[string]time.Time),
+		backlog:    make([]backlogMessage, 0, scrollback),
+		visibility: visibilityPublic,
+		allowed:    make(map[string]bool),
+		created:    time.Now(),
+	}
+}
+
+// roomRecord is how persistent rooms are saved
+type roomRecord struct {
+	Name        string
+	Key         string
+	Topic       string
+	Description string
+	Created     time.Time
+	Owner       string
+	OwnerName   string
+	Ops         map[string]string
+	SlowMode    time.Duration
+	ReadOnly    bool
+	Visibility  string
+	Allowed     map[string]bool
+
+	PasswordSalt string
+	PasswordHash string
+
+	Pins []pinnedMessage
+}
+
+type pinnedMessage struct {
+	ID       string
+	Time     time.Time
+	Sender   string
+	Text     string
+	PinnedBy string
+}
+
+var saveRoomsMutex sync.Mutex
+
+func init() {
+	records := make([]roomRecord, 0)
+	if err := loadJSON("rooms.json", &records); err != nil {
+		l.Println("Error reading rooms:", err)
+		return
+	}
+	for _, rec := range records {
+		r := addRoom(newRoom(rec.Name)) // gets #main if this is it
+		r.persistent = true
+		if rec.Key != "" { // rooms saved before keys were added keep the new one
+			r.key = rec.Key
+		}
+		r.topic = rec.Topic
+		r.description = rec.Description
+		r.created = rec.Created
+		r.owner = rec.Owner
+		r.ownerName = rec.OwnerName
+		r.slowMode = rec.SlowMode
+		r.readOnly = rec.ReadOnly
+		r.visibility = rec.Visibility
+		r.passwordSalt = rec.PasswordSalt
+		r.passwordHash = rec.PasswordHash
+		if rec.Ops != nil {
+			r.ops = rec.Ops
+		}
+		if rec.Allowed != nil {
+			r.allowed = rec.Allowed
+		}
+		r.pins = rec.Pins
+		if r.visibility == "" {
+			r.visibility = visibilityPublic
+		}
+	}
+}
+
+// getRoom finds a room by name
+func getRoom(name string) (*room, bool) {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+	r, ok := rooms[name]
+	return r, ok
+}
+
+// allRooms returns every room, so they can be looped over while rooms are made and deleted
+func allRooms() []*room {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+	list := make([]*room, 0, len(rooms))
+	for _, r := range rooms {
+		list = append(list, r)
+	}
+	return list
+}
+
+// addRoom adds r, unless there's already a room with its name. It returns the room that has the name.
+func addRoom(r *room) *room {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+	if existing, ok := rooms[r.name]; ok {
+		return existing
+	}
+	rooms[r.name] = r
+	return r
+}
+
+// saveRooms saves all persistent rooms and #main
+func saveRooms() {
+	saveRoomsMutex.Lock()
+	defer saveRoomsMutex.Unlock()
+	records := make([]roomRecord, 0)
+	for _, r := range allRooms() {
+		if !r.persistent && r != mainRoom {
+			continue
+		}
+		r.usersMutex.Lock()
+		rec := roomRecord{
+			Name:         r.name,
+			Key:          r.key,
+			Topic:        r.topic,
+			Description:  r.description,
+			Created:      r.created,
+			Owner:        r.owner,
+			OwnerName:    r.ownerName,
+			Ops:          make(map[string]string, len(r.ops)),
+			SlowMode:     r.slowMode,
+			ReadOnly:     r.readOnly,
+			Visibility:   r.visibility,
+			Allowed:      make(map[string]bool, len(r.allowed)),
+			PasswordSalt: r.passwordSalt,
+			PasswordHash: r.passwordHash,
+			Pins:         append([]pinnedMessage(nil), r.pins...),
+		}
+		for k, v := range r.ops {
+			rec.Ops[k] = v
+		}
+		for k, v := range r.allowed {
+			rec.Allowed[k] = v
+		}
+		r.usersMutex.Unlock()
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	if err := saveJSON("rooms.json", records); err != nil {
+		l.Println("Error saving rooms:", err)
+	}
+}
+
+// joinInfo is shown to users when they join the room
+func (r *room) joinInfo() string {
+	info := ""
+	if r.topic != "" {
+		info += "Topic for " + r.name + ": " + r.topic + "  \n"
+	}
+	r.usersMutex.Lock()
+	pins := r.pins
+	r.usersMutex.Unlock()
+	if len(pins) > 0 {
+		info += "Pinned:  \n"
+		for i, p := range pins {
+			if i == 5 {
+				info += "and " + strconv.Itoa(len(pins)-5) + " more, run pins to see all  \n"
+				break
+			}
+			info += "[" + p.ID + "] " + p.Sender + ": " + snippet(p.Text, 60) + "  \n"
+		}
+	}
+	return strings.TrimSpace(info)
+}
+
+// isOp reports if a user can moderate a room. Admins can moderate every room and owners their own.
+func (r *room) isOp(u *user) bool {
+	return r.isOpID(u.id)
+}
+
+// isOpID is like isOp but works for people who aren't online
+func (r *room) isOpID(id string) bool {
+	if isAdmin(id) || (r.owner != "" && r.owner == id) {
+		return true
+	}
+	r.usersMutex.Lock()
+	defer r.usersMutex.Unlock()
+	_, ok := r.ops[id]
+	return ok
+}
+
+func (r *room) isHidden() bool {
+	return r.visibility != visibilityPublic
+}
+
+// isListedFor reports if a user should be able to see a room in lists and completions
+func (r *room) isListedFor(u *user) bool {
+	return u.room == r || r.isListedForID(u.id)
+}
+
+// isListedForID is like isListedFor but works for users who aren't online
+func (r *room) isListedForID(id string) bool {
+	if !r.isHidden() || isAdmin(id) {
+		return true
+	}
+	r.usersMutex.Lock()
+	defer r.usersMutex.Unlock()
+	return r.allowed[id]
+}
+
+// admit checks if a user may join a room, asking for the password if needed
+func (r *room) admit(u *user) bool {
+	if r.visibility == visibilityPublic || r.visibility == visibilityUnlisted || r.isOp(u) {
+		return true
+	}
+	r.usersMutex.Lock()
+	allowed := r.allowed[u.id]
+	r.usersMutex.Unlock()
+	if allowed {
+		return true
+	}
+	if r.visibility == visibilityInvite || u.isSlack {
+		u.writeln(devbot, r.name+" is invite-only. Ask an op to invite you.")
+		return false
+	}
+	pass, err := u.term.ReadPassword("Password for " + r.name + ": ")
+	if err != nil || !r.checkPassword(pass) {
+		u.writeln(devbot, "Wrong password")
+		return false
+	}
+	r.usersMutex.Lock()
+	r.allowed[u.id] = true
+	r.usersMutex.Unlock()
+	return true
+}
+
+func (r *room) setPassword(pass string) {
+	salt := make([]byte, 16)
+	rand.Read(salt) //nolint:errcheck // crypto/rand doesn't fail on supported platforms
+	r.passwordSalt = hex.EncodeToString(salt)
+	r.passwordHash = shasum(r.passwordSalt + pass)
+}
+
+func (r *room) checkPassword(pass string) bool {
+	return r.passwordHash != "" && shasum(r.passwordSalt+pass) == r.passwordHash
+}
+
+// displayName is the room's name, unless the room is hidden
+func (r *room) displayName() string {
+	if r.isHidden() {
+		return "another room"
+	}
+	return r.name
+}
+
+// isModerator reports if a user is an admin or an op of any room
+func isModerator(u *user) bool {
+	if auth(u) {
+		return true
+	}
+	for _, r := range allRooms() {
+		r.usersMutex.Lock()
+		_, ok := r.ops[u.id]
+		r.usersMutex.Unlock()
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPost returns why a user can't post in a room right now, or an empty string if they can.
+// Ops can always post.
+func (r *room) checkPost(u *user) string {
+	return r.checkPostID(u.id)
+}
+
+// checkPostID is like checkPost but works for people who aren't online
+func (r *room) checkPostID(id string) string {
+	if r.isOpID(id) {
+		return ""
+	}
+	if r.readOnly {
+		return r.name + " is read-only. Only ops can post here."
+	}
+	if r.slowMode > 0 {
+		r.usersMutex.Lock()
+		wait := r.slowMode - time.Since(r.lastPost[id])
+		r.usersMutex.Unlock()
+		if wait > 0 {
+			return "Slow mode is on in " + r.name + ". You can post again in " + wait.Round(time.Second).String() + "."
+		}
+	}
+	return ""
+}
+
+// recordPost notes when a user last posted, for slow mode
+func (r *room) recordPost(u *user) {
+	r.recordPostID(u.id)
+}
+
+// recordPostID is like recordPost but works for people who aren't online
+func (r *room) recordPostID(id string) {
+	r.usersMutex.Lock()
+	defer r.usersMutex.Unlock()
+	if r.slowMode > 0 {
+		r.lastPost[id] = time.Now()
+	}
+}
+
+func (r *room) describe() string {
+	settings := make([]string, 0, 5)
+	settings = append(settings, r.visibility)
+	if r.persistent {
+		settings = append(settings, "persistent")
+	}
+	if r.ownerName != "" {
+		settings = append(settings, "owned by "+r.ownerName)
+	}
+	settings = append(settings, "created "+printPrettyDuration(time.Since(r.created))+" ago")
+	if r.slowMode > 0 {
+		settings = append(settings, "slow mode "+r.slowMode.String())
+	}
+	if r.readOnly {
+		settings = append(settings, "read-only")
+	}
+	r.usersMutex.Lock()
+	ops := make([]string, 0, len(r.ops))
+	for _, name := range r.ops {
+		ops = append(ops, name)
+	}
+	r.usersMutex.Unlock()
+	if len(ops) > 0 {
+		settings = append(settings, "ops: "+strings.Join(ops, ", "))
+	}
+	result := r.name + ": " + strings.Join(settings, ", ")
+	if r.topic != "" {
+		result += "  \nTopic: " + r.topic
+	}
+	if r.description != "" {
+		result += "  \n" + r.description
+	}
+	return result
+}
+
+func roomCMD(rest string, u *user) {
+	args := strings.Fields(rest)
+	if len(args) == 0 {
+		u.room.broadcast(devbot, u.room.describe())
+		return
+	}
+	if u.messaging != nil {
+		u.writeln(devbot, "You're in DMs, there's no room to change")
+		return
+	}
+	r := u.room
+	if !r.isOp(u) {
+		u.room.broadcast(devbot, "Not authorized. Only ops and admins can change room settings.")
+		return
+	}
+	defer saveRooms()
+	switch args[0] {
+	case "slow":
+		if len(args) < 2 {
+			r.broadcast(devbot, "Usage: room slow <duration>|off")
+			return
+		}
+		if args[1] == "off" {
+			r.slowMode = 0
+			r.broadcast(devbot, "Slow mode is off")
+			return
+		}
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d <= 0 {
+			r.broadcast(devbot, "I couldn't parse that as a duration")
+			return
+		}
+		r.slowMode = d
+		r.broadcast(devbot, "Slow mode is on: everyone except ops can post once every "+d.String())
+	case "readonly":
+		if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+			r.broadcast(devbot, "Usage: room readonly on|off")
+			return
+		}
+		r.readOnly = args[1] == "on"
+		if r.readOnly {
+			r.broadcast(devbot, r.name+" is now read-only. Only ops can post.")
+		} else {
+			r.broadcast(devbot, r.name+" is no longer read-only")
+		}
+	case "op", "deop":
+		if len(args) < 2 {
+			r.broadcast(devbot, "Usage: room "+args[0]+" <user>")
+			return
+		}
+		victim, ok := findUserByName(r, strings.TrimPrefix(args[1], "@"))
+		if !ok {
+			r.broadcast(devbot, "User not found")
+			return
+		}
+		r.usersMutex.Lock()
+		if args[0] == "op" {
+			r.ops[victim.id] = stripansi.Strip(victim.name)
+		} else {
+			delete(r.ops, victim.id)
+		}
+		r.usersMutex.Unlock()
+		if args[0] == "op" {
+			r.broadcast(devbot, victim.name+" is now an op in "+r.name)
+		} else {
+			r.broadcast(devbot, victim.name+" is no longer an op in "+r.name)
+		}
+	case "visibility":
+		if len(args) < 2 || (args[1] != visibilityPublic && args[1] != visibilityUnlisted && args[1] != visibilityInvite && args[1] != visibilityPassword) {
+			r.broadcast(devbot, "Usage: room visibility public|unlisted|invite|password")
+			return
+		}
+		if r == mainRoom {
+			r.broadcast(devbot, "#main is always public")
+			return
+		}
+		if args[1] == visibilityPassword {
+			if u.isSlack {
+				r.broadcast(devbot, "Set the password over SSH")
+				return
+			}
+			pass, err := u.term.ReadPassword("New password for " + r.name + ": ")
+			if err != nil || pass == "" {
+				u.writeln(devbot, "No password set, so the visibility wasn't changed")
+				return
+			}
+			r.usersMutex.Lock()
+			r.setPassword(pass)
+			r.allowed = make(map[string]bool) // people need the new password
+			r.usersMutex.Unlock()
+		}
+		r.visibility = args[1]
+		r.broadcast(devbot, r.name+" is now "+r.visibility)
+	case "persist":
+		if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+			r.broadcast(devbot, "Usage: room persist on|off")
+			return
+		}
+		if r == mainRoom {
+			r.broadcast(devbot, "#main is always kept")
+			return
+		}
+		r.persistent = args[1] == "on"
+		if r.persistent {
+			r.broadcast(devbot, r.name+" will be kept even when it's empty")
+		} else {
+			r.broadcast(devbot, r.name+" will be deleted when it's empty")
+		}
+	case "desc":
+		r.description = strings.TrimSpace(strings.TrimPrefix(rest, args[0]))
+		r.broadcast(devbot, "Description updated")
+	default:
+		r.broadcast(devbot, "Usage: room [slow <duration>|off] [readonly on|off] [op|deop <user>] [visibility public|unlisted|invite|password] [persist on|off] [desc <text>]")
+	}
+}
+
+func inviteCMD(rest string, u *user) {
+	r := u.room
+	if rest == "" {
+		r.broadcast(devbot, "Who do you want to invite?")
+		return
+	}
+	if !r.isOp(u) {
+		r.broadcast(devbot, "Not authorized. Only ops can invite people.")
+		return
+	}
+	peer, ok := findUserByNameAnywhere(strings.TrimPrefix(rest, "@"))
+	if !ok {
+		r.broadcast(devbot, "User not found")
+		return
+	}
+	r.usersMutex.Lock()
+	r.allowed[peer.id] = true
+	r.usersMutex.Unlock()
+	if r.persistent {
+		saveRooms()
+	}
+	r.broadcast(devbot, u.name+" invited "+peer.name)
+	peer.writeln(devbot, u.name+" invited you to "+r.name+". Run cd "+r.name+" to join.")
+}
+
+func topicCMD(rest string, u *user) {
+	r := u.room
+	if rest == "" {
+		if r.topic == "" {
+			r.broadcast(devbot, r.name+" has no topic. Set one with topic <text>")
+		} else {
+			r.broadcast(devbot, "Topic for "+r.name+": "+r.topic)
+		}
+		return
+	}
+	if !r.isOp(u) {
+		r.broadcast(devbot, "Not authorized. Only ops can change the topic.")
+		return
+	}
+	if rest == "clear" {
+		rest = ""
+	}
+	r.topic = rest
+	saveRooms()
+	if r.topic == "" {
+		r.broadcast(devbot, u.name+" cleared the topic")
+	} else {
+		r.broadcast(devbot, u.name+" changed the topic to: "+r.topic)
+	}
+}
+
+func pinCMD(rest string, u *user) {
+	r := u.room
+	if rest == "" {
+		r.broadcast(devbot, "Usage: pin <message id>")
+		return
+	}
+	if !r.isOp(u) {
+		r.broadcast(devbot, "Not authorized. Only ops can pin messages.")
+		return
+	}
+	m, ok := r.findMessage(rest)
+	if !ok || m.deleted || m.id == "" {
+		r.broadcast(devbot, "I couldn't find that message. It may be too old.")
+		return
+	}
+	r.usersMutex.Lock()
+	for _, p := range r.pins {
+		if p.ID == m.id {
+			r.usersMutex.Unlock()
+			r.broadcast(devbot, "That message is already pinned")
+			return
+		}
+	}
+	r.pins = append(r.pins, pinnedMessage{m.id, m.timestamp, stripansi.Strip(m.senderName), strings.TrimSpace(m.text), stripansi.Strip(u.name)})
+	r.usersMutex.Unlock()
+	saveRooms()
+	r.broadcast(devbot, u.name+" pinned ["+m.id+"]")
+}
+
+func unpinCMD(rest string, u *user) {
+	r := u.room
+	if rest == "" {
+		r.broadcast(devbot, "Usage: unpin <message id>")
+		return
+	}
+	if !r.isOp(u) {
+		r.broadcast(devbot, "Not authorized. Only ops can unpin messages.")
+		return
+	}
+	id := cleanMessageID(rest)
+	found := false
+	r.usersMutex.Lock()
+	pins := make([]pinnedMessage, 0, len(r.pins))
+	for _, p := range r.pins {
+		if p.ID == id {
+			found = true
+			continue
+		}
+		pins = append(pins, p)
+	}
+	r.pins = pins
+	r.usersMutex.Unlock()
+	if !found {
+		r.broadcast(devbot, "That message isn't pinned")
+		return
+	}
+	saveRooms()
+	r.broadcast(devbot, u.name+" unpinned ["+id+"]")
+}
+
+func pinsCMD(_ string, u *user) {
+	r := u.room
+	r.usersMutex.Lock()
+	pins := r.pins
+	r.usersMutex.Unlock()
+	if len(pins) == 0 {
+		u.writeln(devbot, "Nothing is pinned in "+r.name)
+		return
+	}
+	msg := "Pinned in " + r.name + ":  \n"
+	for _, p := range pins {
+		msg += gray.Paint("["+p.ID+"] "+u.localTime(p.Time).Format("Jan 2 15:04")+" pinned by "+p.PinnedBy) + "  \n" + p.Sender + ": " + p.Text + "  \n"
+	}
+	u.writeln(devbot, msg) // times are in u's timezone
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestRoomHelpers(t *testing.T) {
+	r := newRoom("#roomstest")
+	if got := addRoom(r); got != r {
+		t.Fatalf("addRoom returned %v for a new room", got)
+	}
+	defer cleanupRoom(r)
+	if got := addRoom(newRoom(r.name)); got != r {
+		t.Errorf("addRoom replaced an existing room")
+	}
+	if got, ok := getRoom(r.name); !ok || got != r {
+		t.Errorf("getRoom(%q) = %v, %v", r.name, got, ok)
+	}
+	found := false
+	for _, room := range allRooms() {
+		found = found || room == r
+	}
+	if !found {
+		t.Errorf("allRooms is missing %s", r.name)
+	}
+
+	r.post(&backlogMessage{senderName: devbot, text: "hello @nobody"})
+	backlog := r.getBacklog()
+	if len(backlog) != 1 || backlog[0].text != "hello @nobody\n" {
+		t.Errorf("backlog after post = %+v", backlog)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/acarl005/stripansi"
+	"github.com/gliderlabs/ssh"
+	"github.com/mattn/go-runewidth"
+)
+
+const (
+	maxScrollback = 200
+	resizeDelay   = 200 * time.Millisecond // wait for the window to stop changing size before redrawing
+)
+
+// printed is something written to a user's terminal, kept unrendered so it can be drawn again at a different width
+type printed struct {
+	senderName string
+	msg        string
+	suffix     string
+	right      bool // right-aligned, like timestamps
+}
+
+// remember adds to u's scrollback
+func (u *user) remember(p printed) {
+	u.scrollbackMutex.Lock()
+	defer u.scrollbackMutex.Unlock()
+	u.scrollback = append(u.scrollback, p)
+	if len(u.scrollback) > maxScrollback {
+		u.scrollback = append([]printed(nil), u.scrollback[len(u.scrollback)-maxScrollback:]...)
+	}
+}
+
+// forgetScrollback is used when the screen is cleared, so redraws don't bring anything back
+func (u *user) forgetScrollback() {
+	u.scrollbackMutex.Lock()
+	u.scrollback = nil
+	u.scrollbackMutex.Unlock()
+}
+
+// render formats something for a terminal of the width
+func (u *user) render(p printed, width int) string {
+	msg := p.msg
+	if p.right {
+		if width-lenString(msg) > 0 {
+			return strings.Repeat(" ", width-lenString(msg)) + msg
+		}
+		return msg
+	}
+	msg = strings.ReplaceAll(msg, `\n`, "\n")
+	msg = strings.ReplaceAll(msg, `\`+"\n", `\n`) // let people escape newlines
+	if p.senderName != "" {
+		if strings.HasSuffix(p.senderName, " <- ") || strings.HasSuffix(p.senderName, " -> ") { // TODO: kinda hacky DM detection
+			msg = strings.TrimSpace(mdRender(msg, lenString(p.senderName), width))
+			msg = p.senderName + msg + "\a"
+		} else {
+			msg = strings.TrimSpace(mdRender(msg, lenString(p.senderName)+2, width))
+			msg = p.senderName + ": " + msg
+		}
+	} else {
+		msg = strings.TrimSpace(mdRender(msg, 0, width)) // No sender
+	}
+	if p.suffix != "" {
+		msg += " " + p.suffix
+	}
+	return msg
+}
+
+// size returns the size of u's terminal
+func (u *user) size() ssh.Window {
+	u.screenMutex.Lock()
+	defer u.screenMutex.Unlock()
+	return u.win
+}
+
+// watchResize keeps u.win up to date and redraws the screen when the width changes, or the height in full-screen mode
+func (u *user) watchResize(winChan <-chan ssh.Window) {
+	var timer *time.Timer
+	for win := range winChan {
+		u.screenMutex.Lock()
+		resized := win.Width != u.win.Width || (u.tui != nil && win.Height != u.win.Height)
+		u.win = win
+		u.screenMutex.Unlock()
+		if !resized {
+			continue
+		}
+		if timer == nil {
+			timer = time.AfterFunc(resizeDelay, u.redraw)
+		} else {
+			timer.Reset(resizeDelay)
+		}
+	}
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// redraw clears the screen and renders as much of the scrollback as fits at the current size
+func (u *user) redraw() {
+	if u.fullScreen() != nil {
+		u.drawOrClose()
+		return
+	}
+	if u.composing { // the draft on screen would be lost
+		return
+	}
+	win := u.size()
+	width, height := win.Width, win.Height
+	u.scrollbackMutex.Lock()
+	lines := make([]string, 0, 20)
+	rows := 0
+	for i := len(u.scrollback) - 1; i >= 0 && rows < height-1; i-- { // leave a row for the prompt
+		s := strings.ReplaceAll(u.render(u.scrollback[i], width), "\a", "") // don't ring again
+		rows += linesTaken(s, width)
+		lines = append(lines, s)
+	}
+	u.scrollbackMutex.Unlock()
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	out := "\033[H\033[2J"
+	if len(lines) > 0 {
+		out += strings.Join(lines, "\n") + "\n"
+	}
+	u.term.Write([]byte(out))
+}
+
+// linesTaken works out how many rows s takes up on a terminal of the width, wrapping like the terminal does.
+// Wide characters like emoji take two columns, and a row that's exactly full doesn't wrap until something comes after it.
+func linesTaken(s string, width int) int {
+	s = stripansi.Strip(s)
+	rows := 1
+	col := 0
+	for _, r := range s {
+		switch r {
+		case '\n':
+			rows++
+			col = 0
+			continue
+		case '\r':
+			col = 0
+			continue
+		case '\t':
+			col += 8 - col%8
+			if width > 0 && col > width-1 { // tabs stop at the last column
+				col = width - 1
+			}
+			continue
+		}
+		w := runewidth.RuneWidth(r)
+		if w == 0 {
+			continue
+		}
+		if width > 0 && col+w > width {
+			rows++
+			col = 0
+		}
+		col += w
+	}
+	return rows
+}
+
+// eraseLines erases the n rows above the cursor, like the one the user just typed.
+// In full-screen mode the screen is drawn again instead, scrolled to the newest message.
+func (u *user) eraseLines(n int) {
+	if t := u.fullScreen(); t != nil {
+		t.mutex.Lock()
+		t.scroll = 0
+		t.mutex.Unlock()
+		u.scheduleDraw()
+		return
+	}
+	u.term.Write([]byte(strings.Repeat("\033[A\033[2K", n)))
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/acarl005/stripansi"
+	"github.com/mattn/go-runewidth"
+)
+
+const (
+	drawDelay    = 20 * time.Millisecond // lets a burst of messages be drawn at once
+	sidebarWidth = 18
+	userWidth    = 18
+
+	enterAltScreen = "\033[?1049h"
+	leaveAltScreen = "\033[?1049l"
+)
+
+// tuiState is kept for users in full-screen mode
+type tuiState struct {
+	mutex   sync.Mutex
+	unread  map[string]int // room name to messages sent there since the user last looked
+	scroll  int            // lines scrolled up from the newest message
+	pending bool           // set if a draw is scheduled
+}
+
+// fullScreen returns u's full-screen state, or nil if they're in line mode
+func (u *user) fullScreen() *tuiState {
+	u.screenMutex.Lock()
+	defer u.screenMutex.Unlock()
+	return u.tui
+}
+
+// startTUI switches u to full-screen mode
+func (u *user) startTUI() {
+	u.screenMutex.Lock()
+	if u.tui != nil {
+		u.screenMutex.Unlock()
+		return
+	}
+	u.tui = &tuiState{unread: make(map[string]int)}
+	u.screenMutex.Unlock()
+	u.session.Write([]byte(enterAltScreen)) //nolint:errcheck // the next write will fail too
+	u.drawOrClose()
+}
+
+// stopTUI goes back to line mode, showing what was said meanwhile
+func (u *user) stopTUI() {
+	u.screenMutex.Lock()
+	if u.tui == nil {
+		u.screenMutex.Unlock()
+		return
+	}
+	u.tui = nil
+	u.screenMutex.Unlock()
+	u.term.Write([]byte(leaveAltScreen))
+	u.redraw()
+}
+
+// scheduleDraw draws u's screen soon, if they're in full-screen mode
+func (u *user) scheduleDraw() {
+	t := u.fullScreen()
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.pending {
+		return
+	}
+	t.pending = true
+	time.AfterFunc(drawDelay, func() {
+		t.mutex.Lock()
+		t.pending = false
+		t.mutex.Unlock()
+		u.drawOrClose()
+	})
+}
+
+// scrollTUI moves the message pane by half a screen
+func (u *user) scrollTUI(up bool) {
+	t := u.fullScreen()
+	if t == nil {
+		return
+	}
+	step := (u.size().Height - 3) / 2
+	if step < 1 {
+		step = 1
+	}
+	t.mutex.Lock()
+	if up {
+		t.scroll += step
+	} else if t.scroll -= step; t.scroll < 0 {
+		t.scroll = 0
+	}
+	t.mutex.Unlock()
+	u.drawOrClose()
+}
+
+// countUnread adds a message in r to the unread counts of users in full-screen mode who are elsewhere
+func countUnread(r *room, m *backlogMessage) {
+	if m.senderID == "" {
+		return
+	}
+	for _, us := range onlineUsers() {
+		t := us.fullScreen()
+		if t == nil || us.room == r || !r.isListedFor(us) || us.isIgnoring(m.senderID) {
+			continue
+		}
+		t.mutex.Lock()
+		t.unread[r.name]++
+		t.mutex.Unlock()
+		us.scheduleDraw()
+	}
+}
+
+// showRoom fills the message pane with what was last said in r, after u moves there in full-screen mode
+func (u *user) showRoom(r *room) {
+	t := u.fullScreen()
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	delete(t.unread, r.name)
+	t.scroll = 0
+	t.mutex.Unlock()
+	u.forgetScrollback()
+	u.printBacklog(r)
+}
+
+// drawOrClose draws u's screen, closing their session if it can't be written to
+func (u *user) drawOrClose() {
+	if err := u.draw(); err != nil {
+		u.close(u.name + " has left the chat because of an error writing to their terminal: " + err.Error())
+	}
+}
+
+// draw draws the whole screen: the room list, messages and users between a title and the input line
+func (u *user) draw() error {
+	t := u.fullScreen()
+	if t == nil {
+		return nil
+	}
+	win := u.size()
+	width, height := win.Width, win.Height
+	if height < 4 {
+		height = 4
+	}
+	left, right := sidebarWidth, userWidth
+	if width < 80 {
+		right = 0
+	}
+	if width < 50 {
+		left = 0
+	}
+	paneWidth := width - left - right
+	if paneWidth < 1 {
+		paneWidth = 1
+	}
+	bodyHeight := height - 3
+
+	t.mutex.Lock()
+	unread := make(map[string]int, len(t.unread))
+	for name, n := range t.unread {
+		unread[name] = n
+	}
+	t.mutex.Unlock()
+	messages, scroll := u.paneLines(t, paneWidth, bodyHeight)
+	var sidebar, users []string
+	if left > 0 {
+		sidebar = u.roomList(unread)
+	}
+	if right > 0 {
+		users = u.userList()
+	}
+
+	b := new(strings.Builder)
+	b.WriteString("\033[H")
+	title := " devzat · " + u.room.name
+	if u.messaging != nil {
+		title += " · DMs with " + stripansi.Strip(u.messaging.name)
+	} else if u.room.topic != "" {
+		title += " · " + u.room.topic
+	}
+	b.WriteString("\033[7m" + fitCell(title, width) + "\033[0m")
+	for i := 0; i < bodyHeight; i++ {
+		b.WriteString("\033[" + strconv.Itoa(i+2) + ";1H")
+		if left > 0 {
+			b.WriteString(fitCell(cellAt(sidebar, i), left-1) + gray.Paint("│"))
+		}
+		b.WriteString(fitCell(cellAt(messages, i), paneWidth))
+		if right > 0 {
+			b.WriteString(gray.Paint("│") + fitCell(cellAt(users, i), right-1))
+		}
+	}
+	help := "tui to leave · PgUp/PgDn to scroll"
+	if scroll > 0 {
+		help = "scrolled up " + strconv.Itoa(scroll) + " lines · " + help
+	}
+	b.WriteString("\033[" + strconv.Itoa(height) + ";1H" + gray.Paint(fitCell(help, width)))
+	b.WriteString("\033[" + strconv.Itoa(height-1) + ";1H\033[2K") // the terminal draws the prompt here
+	_, err := u.term.Write([]byte(b.String()))
+	return err
+}
+
+// paneLines renders the newest part of the scrollback that fits in the message pane, taking scrolling into account.
+// It also returns how far the pane is scrolled, which is limited to the scrollback there is.
+func (u *user) paneLines(t *tuiState, width, height int) ([]string, int) {
+	t.mutex.Lock()
+	scroll := t.scroll
+	t.mutex.Unlock()
+	lines := make([]string, 0, height+scroll)
+	u.scrollbackMutex.Lock()
+	for i := len(u.scrollback) - 1; i >= 0 && len(lines) < height+scroll; i-- {
+		rendered := strings.ReplaceAll(u.render(u.scrollback[i], width), "\a", "")
+		wrapped := make([]string, 0, 4)
+		for _, line := range strings.Split(rendered, "\n") {
+			wrapped = append(wrapped, wrapCell(line, width)...)
+		}
+		for j := len(wrapped) - 1; j >= 0; j-- { // lines is newest first for now
+			lines = append(lines, wrapped[j])
+		}
+	}
+	u.scrollbackMutex.Unlock()
+	if scroll > len(lines)-height { // can't scroll past the oldest message
+		scroll = len(lines) - height
+		if scroll < 0 {
+			scroll = 0
+		}
+		t.mutex.Lock()
+		t.scroll = scroll
+		t.mutex.Unlock()
+	}
+	lines = lines[scroll:]
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	if len(lines) < height { // keep messages at the bottom
+		lines = append(make([]string, height-len(lines)), lines...)
+	}
+	return lines, scroll
+}
+
+// roomList lists the rooms u can see, with their unread counts
+func (u *user) roomList(unread map[string]int) []string {
+	names := make([]string, 0, 10)
+	for _, r := range allRooms() {
+		if r.isListedFor(u) || r == u.room {
+			names = append(names, r.name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == mainRoom.name) != (names[j] == mainRoom.name) {
+			return names[i] == mainRoom.name
+		}
+		return names[i] < names[j]
+	})
+	list := []string{gray.Paint("Rooms")}
+	for _, name := range names {
+		switch {
+		case name == u.room.name:
+			list = append(list, "\033[7m"+name+"\033[0m")
+		case unread[name] > 0:
+			list = append(list, name+" "+yellow.Paint("("+strconv.Itoa(unread[name])+")"))
+		default:
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+// userList lists the users in u's room
+func (u *user) userList() []string {
+	r := u.room
+	r.usersMutex.Lock()
+	users := append(make([]*user, 0, len(r.users)), r.users...)
+	r.usersMutex.Unlock()
+	sort.Slice(users, func(i, j int) bool { return stripansi.Strip(users[i].name) < stripansi.Strip(users[j].name) })
+	list := []string{gray.Paint("Users (" + strconv.Itoa(len(users)) + ")")}
+	for _, us := range users {
+		if us.isAway() {
+			list = append(list, gray.Paint(stripansi.Strip(us.name)+" (away)"))
+		} else {
+			list = append(list, us.name)
+		}
+	}
+	return list
+}
+
+func cellAt(lines []string, i int) string {
+	if i < len(lines) {
+		return lines[i]
+	}
+	return ""
+}
+
+// fitCell cuts s to the width and pads it with spaces, keeping its colors
+func fitCell(s string, width int) string {
+	b := new(strings.Builder)
+	col := 0
+	for i := 0; i < len(s); {
+		if seq := escapeAt(s, i); seq != "" {
+			b.WriteString(seq)
+			i += len(seq)
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		w := runewidth.RuneWidth(r)
+		if col+w > width {
+			break
+		}
+		b.WriteRune(r)
+		col += w
+		i += size
+	}
+	b.WriteString("\033[0m")
+	if col < width {
+		b.WriteString(strings.Repeat(" ", width-col))
+	}
+	return b.String()
+}
+
+// wrapCell splits s into lines no wider than the width, carrying colors over to the next line
+func wrapCell(s string, width int) []string {
+	lines := make([]string, 0, 1)
+	b := new(strings.Builder)
+	lastStyle := ""
+	col := 0
+	for i := 0; i < len(s); {
+		if seq := escapeAt(s, i); seq != "" {
+			b.WriteString(seq)
+			lastStyle = seq
+			i += len(seq)
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		w := runewidth.RuneWidth(r)
+		if r == '\t' {
+			r, w = ' ', 1
+		}
+		if col+w > width && col > 0 {
+			lines = append(lines, b.String())
+			b.Reset()
+			b.WriteString(lastStyle)
+			col = 0
+		}
+		b.WriteRune(r)
+		col += w
+		i += size
+	}
+	return append(lines, b.String())
+}
+
+// escapeAt returns the ANSI escape sequence starting at s[i], if there is one
+func escapeAt(s string, i int) string {
+	if s[i] != '\033' || i+1 >= len(s) || s[i+1] != '[' {
+		return ""
+	}
+	for j := i + 2; j < len(s); j++ {
+		if s[j] >= 0x40 && s[j] <= 0x7e {
+			return s[i : j+1]
+		}
+	}
+	return s[i:]
+}
+
+func tuiCMD(rest string, u *user) {
+	if u.isSlack {
+		return
+	}
+	switch rest {
+	case "":
+		if u.fullScreen() != nil {
+			u.stopTUI()
+			u.writeln(devbot, "Back to line mode. Run tui to go full-screen again.")
+			return
+		}
+		u.startTUI()
+		u.writeln(devbot, "Welcome to full-screen mode. Run tui again to leave.")
+	case "login on", "login off":
+		on := rest == "login on"
+		updateProfile(u.id, func(p *profile) { p.TUI = on })
+		if on {
+			u.writeln(devbot, "You'll start in full-screen mode when you connect")
+		} else {
+			u.writeln(devbot, "You'll start in line mode when you connect")
+		}
+	default:
+		u.writeln(devbot, "Usage: tui [login on|off]")
+	}
+}
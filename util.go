@@ -6,11 +6,13 @@ import (
 	_ "embed"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -19,24 +21,30 @@ import (
 	markdown "github.com/quackduck/go-term-markdown"
 )
 
-var (
-	art    = getASCIIArt()
-	admins = getAdmins()
-)
-
 func getAdmins() map[string]string {
-	data, err := ioutil.ReadFile("admins.json")
+	adminsList, err := readAdmins()
 	if err != nil {
-		fmt.Println("Error reading admins.json:", err, ". Make an admins.json file to add admins.")
+		fmt.Println(err)
 		return nil
 	}
-	var adminsList map[string]string // id to info
+	return adminsList
+}
+
+// readAdmins reads the admins.json file, which maps admin IDs to info about them.
+func readAdmins() (map[string]string, error) {
+	data, err := ioutil.ReadFile("admins.json")
+	if os.IsNotExist(err) { // no admins yet
+		return make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, errors.New("Error reading admins.json: " + err.Error() + ". Make an admins.json file to add admins.")
+	}
+	adminsList := make(map[string]string) // id to info
 	err = json.Unmarshal(data, &adminsList)
 	if err != nil {
-		fmt.Println("Error in admins.json formatting.")
-		return nil
+		return nil, errors.New("Error in admins.json formatting: " + err.Error())
 	}
-	return adminsList
+	return adminsList, nil
 }
 
 func getASCIIArt() string {
@@ -51,11 +59,15 @@ func printUsersInRoom(r *room) string {
 	names := ""
 	admins := ""
 	for _, us := range r.users {
+		name := us.name
+		if us.isAway() {
+			name += gray.Paint("(away)")
+		}
 		if auth(us) {
-			admins += us.name + " "
+			admins += name + " "
 			continue
 		}
-		names += us.name + " "
+		names += name + " "
 	}
 	if len(names) > 0 {
 		names = names[:len(names)-1] // cut extra space at the end
@@ -84,10 +96,20 @@ func autogenCommands(cmds []cmd) string {
 
 // check if a user is an admin
 func auth(u *user) bool {
-	_, ok := admins[u.id]
+	return isAdmin(u.id)
+}
+
+// isAdmin is like auth but takes an ID, for people who aren't online
+func isAdmin(id string) bool {
+	_, ok := getSettings().admins[id]
 	return ok
 }
 
+// isPrintableASCII reports if c is a printable ASCII character other than space, the only ones allowed in names
+func isPrintableASCII(c byte) bool {
+	return 33 <= c && c <= 126 // '!' to '~'
+}
+
 // removes arrows, spaces and non-ascii-printable characters
 func cleanName(name string) string {
 	s := ""
@@ -96,11 +118,11 @@ func cleanName(name string) string {
 		"<-", ""),
 		"->", ""),
 		" ", "-")
-	if len([]rune(name)) > 27 {
-		name = string([]rune(name)[:27])
+	if len([]rune(name)) > maxLengthName {
+		name = string([]rune(name)[:maxLengthName])
 	}
 	for i := 0; i < len(name); i++ {
-		if 33 <= name[i] && name[i] <= 126 { // ascii printables only: '!' to '~'
+		if isPrintableASCII(name[i]) {
 			s += string(name[i])
 		}
 	}
@@ -115,6 +137,22 @@ func printPrettyDuration(d time.Duration) string {
 	return s
 }
 
+// localTime converts t to the user's timezone, or UTC if they haven't set one
+func (u *user) localTime(t time.Time) time.Time {
+	if u.timezone == nil {
+		return t.UTC()
+	}
+	return t.In(u.timezone)
+}
+
+// formatTime formats a date and time in the user's timezone and clock format
+func (u *user) formatTime(t time.Time) string {
+	if u.formatTime24 {
+		return u.localTime(t).Format("Jan 2 15:04")
+	}
+	return u.localTime(t).Format("Jan 2 3:04 pm")
+}
+
 func mdRender(a string, beforeMessageLen int, lineWidth int) string {
 	if strings.Contains(a, "![") && strings.Contains(a, "](") {
 		lineWidth = int(math.Min(float64(lineWidth/2), 200)) // max image width is 200
@@ -144,7 +182,25 @@ func userDuplicate(r *room, a string) (*user, bool) {
 	return nil, false
 }
 
+// isBanned reports if the address or ID is banned
+func isBanned(addr string, id string) bool {
+	bansMutex.Lock()
+	defer bansMutex.Unlock()
+	return bansContains(bans, addr, id)
+}
+
+// addBan bans an address and ID unless one of them already is. It doesn't save bans to the bans file.
+func addBan(addr string, id string) {
+	bansMutex.Lock()
+	defer bansMutex.Unlock()
+	if !bansContains(bans, addr, id) {
+		bans = append(bans, ban{addr, id})
+	}
+}
+
 func saveBans() {
+	bansMutex.Lock()
+	defer bansMutex.Unlock()
 	f, err := os.Create("bans.json")
 	if err != nil {
 		l.Println(err)
@@ -154,7 +210,7 @@ func saveBans() {
 	j.SetIndent("", "   ")
 	err = j.Encode(bans)
 	if err != nil {
-		rooms["#main"].broadcast(devbot, "error saving bans: "+err.Error())
+		mainRoom.broadcast(devbot, "error saving bans: "+err.Error())
 		l.Println(err)
 		return
 	}
@@ -162,18 +218,65 @@ func saveBans() {
 }
 
 func readBans() {
-	f, err := os.Open("bans.json")
-	if err != nil && !os.IsNotExist(err) { // if there is an error and it is not a "file does not exist" error
+	b, err := loadBans()
+	if err != nil {
+		mainRoom.broadcast(devbot, "error reading bans: "+err.Error())
 		l.Println(err)
 		return
 	}
-	err = json.NewDecoder(f).Decode(&bans)
+	bansMutex.Lock()
+	bans = b
+	bansMutex.Unlock()
+}
+
+// loadBans reads the bans file without modifying the bans list.
+// A missing bans file is not an error.
+func loadBans() ([]ban, error) {
+	b := make([]ban, 0, 10)
+	f, err := os.Open("bans.json")
+	if os.IsNotExist(err) {
+		return b, nil
+	}
 	if err != nil {
-		rooms["#main"].broadcast(devbot, "error reading bans: "+err.Error())
-		l.Println(err)
-		return
+		return nil, err
 	}
-	f.Close()
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// saveJSON writes v as JSON to a file in the data directory
+func saveJSON(name string, v interface{}) error {
+	err := os.MkdirAll(getConfig().DataDir, 0755)
+	if err != nil {
+		return err
+	}
+	d, err := json.MarshalIndent(v, "", "   ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(getConfig().DataDir, name)
+	err = os.WriteFile(file+".tmp", d, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file) // so a crash while writing can't leave a broken file
+}
+
+// loadJSON reads a file in the data directory written by saveJSON into v.
+// A missing file is not an error and leaves v as it is.
+func loadJSON(name string, v interface{}) error {
+	d, err := ioutil.ReadFile(filepath.Join(getConfig().DataDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(d, v)
 }
 
 func findUserByName(r *room, name string) (*user, bool) {
@@ -187,6 +290,31 @@ func findUserByName(r *room, name string) (*user, bool) {
 	return nil, false
 }
 
+// findUserByNameAnywhere is like findUserByName but looks in every room
+func findUserByNameAnywhere(name string) (*user, bool) {
+	for _, r := range allRooms() {
+		if u, ok := findUserByName(r, name); ok {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+// findUserByID finds a user who's online in any room by their ID
+func findUserByID(id string) (*user, bool) {
+	for _, r := range allRooms() {
+		r.usersMutex.Lock()
+		for _, u := range r.users {
+			if u.id == id {
+				r.usersMutex.Unlock()
+				return u, true
+			}
+		}
+		r.usersMutex.Unlock()
+	}
+	return nil, false
+}
+
 func remove(s []*user, a *user) []*user {
 	for j := range s {
 		if s[j] == a {
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acarl005/stripansi"
+)
+
+// session records when someone was last online, so seen works after they leave
+type session struct {
+	Name      string
+	Room      string
+	Connected time.Time
+	LastSeen  time.Time
+}
+
+var (
+	sessions       = make(map[string]*session) // user ID to their last session
+	sessionsByName = make(map[string]string)   // name to the ID of the session with it seen most recently
+	sessionsMutex  sync.Mutex
+)
+
+func init() {
+	if err := loadJSON("sessions.json", &sessions); err != nil {
+		l.Println("Error reading sessions:", err)
+	}
+	for id := range sessions {
+		indexSession(id)
+	}
+}
+
+// indexSession makes the session the one found for its name if it was seen more recently. sessionsMutex must be held.
+func indexSession(id string) {
+	s := sessions[id]
+	if other, ok := sessions[sessionsByName[s.Name]]; !ok || s.LastSeen.After(other.LastSeen) || sessionsByName[s.Name] == id {
+		sessionsByName[s.Name] = id
+	}
+}
+
+// recordSession saves the user's name, room and connection time. If they're leaving, their last seen time is set too.
+func recordSession(u *user, leaving bool) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	s := &session{Name: stripansi.Strip(u.name), Room: u.room.name, Connected: u.joinTime}
+	old, hadOld := sessions[u.id]
+	if leaving {
+		s.LastSeen = time.Now()
+	} else if hadOld {
+		s.LastSeen = old.LastSeen
+	}
+	sessions[u.id] = s
+	indexSession(u.id)
+	if hadOld && old.Name != s.Name && sessionsByName[old.Name] == u.id { // find who else had their old name
+		delete(sessionsByName, old.Name)
+		for id, other := range sessions {
+			if other.Name == old.Name {
+				indexSession(id)
+			}
+		}
+	}
+	if err := saveJSON("sessions.json", sessions); err != nil {
+		l.Println("Error saving sessions:", err)
+	}
+}
+
+// findSession finds the most recent session of someone with the name
+func findSession(name string) (*session, bool) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	s, ok := sessions[sessionsByName[name]]
+	return s, ok
+}
+
+// roomNameFor shows a room's name unless it's hidden from u
+func roomNameFor(u *user, r *room) string {
+	if r.isListedFor(u) {
+		return r.name
+	}
+	return r.displayName()
+}
+
+// roles lists what a user can do, like admin or op of #room
+func (u *user) roles(viewer *user) []string {
+	roles := make([]string, 0, 2)
+	if auth(u) {
+		roles = append(roles, "admin")
+	}
+	for _, r := range allRooms() {
+		if !r.isListedFor(viewer) {
+			continue
+		}
+		if r.owner == u.id {
+			roles = append(roles, "owner of "+r.name)
+			continue
+		}
+		r.usersMutex.Lock()
+		_, op := r.ops[u.id]
+		r.usersMutex.Unlock()
+		if op {
+			roles = append(roles, "op of "+r.name)
+		}
+	}
+	if u.isSlack {
+		roles = append(roles, "on Slack")
+	}
+	if u.isMuted() {
+		roles = append(roles, "muted")
+	}
+	return roles
+}
+
+func whoisCMD(rest string, u *user) {
+	name := strings.TrimPrefix(rest, "@")
+	if name == "" {
+		u.writeln(devbot, "Usage: whois <user>")
+		return
+	}
+	peer, ok := findUserByNameAnywhere(name)
+	if !ok {
+		if s, ok := findSession(name); ok {
+			u.writeln(devbot, name+" isn't online. "+describeSeen(u, s))
+			return
+		}
+		u.writeln(devbot, "User not found")
+		return
+	}
+	msg := "**" + stripansi.Strip(peer.name) + "** " + gray.Paint("("+shortID(peer.id)+")") + "  \n"
+	if pronouns := peer.displayPronouns(); pronouns != "" && pronouns != "unset" {
+		msg += "Pronouns: " + pronouns + "  \n"
+	}
+	prof := getProfile(peer.id)
+	if prof.Status != "" {
+		msg += "Status: " + prof.Status + "  \n"
+	}
+	if prof.Bio != "" {
+		msg += "Bio: " + prof.Bio + "  \n"
+	}
+	msg += "Room: " + roomNameFor(u, peer.room) + "  \n"
+	msg += "Connected: " + u.formatTime(peer.joinTime) + " (" + printPrettyDuration(time.Since(peer.joinTime)) + " ago)  \n"
+	if peer.isAway() {
+		msg += "Away: " + peer.away + " for " + printPrettyDuration(time.Since(peer.awaySince)) + "  \n"
+	} else {
+		msg += "Idle: " + printPrettyDuration(time.Since(peer.lastActive)) + "  \n"
+	}
+	if peer.timezone != nil {
+		layout := "3:04 pm"
+		if u.formatTime24 {
+			layout = "15:04"
+		}
+		msg += "Local time: " + time.Now().In(peer.timezone).Format(layout) + " (" + peer.timezone.String() + ")  \n"
+	}
+	msg += "Terminal: " + strconv.Itoa(peer.size().Width) + "x" + strconv.Itoa(peer.size().Height) + "  \n"
+	if roles := peer.roles(u); len(roles) > 0 {
+		msg += "Roles: " + strings.Join(roles, ", ") + "  \n"
+	}
+	u.writeln(devbot, msg)
+}
+
+// describeSeen says when someone was last online and where
+func describeSeen(u *user, s *session) string {
+	if s.LastSeen.IsZero() {
+		return s.Name + " was online " + printPrettyDuration(time.Since(s.Connected)) + " ago"
+	}
+	where := s.Room
+	if r, ok := getRoom(s.Room); ok {
+		where = roomNameFor(u, r)
+	} else if !auth(u) {
+		where = "a room that's gone now"
+	}
+	return s.Name + " was last seen " + printPrettyDuration(time.Since(s.LastSeen)) + " ago (" + u.formatTime(s.LastSeen) + ") in " + where
+}
+
+func seenCMD(rest string, u *user) {
+	name := strings.TrimPrefix(rest, "@")
+	if name == "" {
+		u.writeln(devbot, "Usage: seen <user>")
+		return
+	}
+	if peer, ok := findUserByNameAnywhere(name); ok {
+		u.writeln(devbot, stripansi.Strip(peer.name)+" is online now in "+roomNameFor(u, peer.room))
+		return
+	}
+	s, ok := findSession(name)
+	if !ok {
+		u.writeln(devbot, "I haven't seen "+name)
+		return
+	}
+	u.writeln(devbot, describeSeen(u, s))
+}